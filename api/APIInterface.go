@@ -0,0 +1,474 @@
+/* SPDX-License-Identifier: GPL-3.0
+ *
+ * Copyright (C) 2018 Brady O'Brien. All Rights Reserved.
+ */
+
+package api
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+/* Flex API Version Info */
+type FlexVersion struct {
+	Major int
+	Minor int
+	DevA  int
+	DevB  int
+}
+
+/* Response from command */
+type CmdResponse struct {
+	RespStr string
+	Status  uint32
+}
+
+type InflightCmd struct {
+	Seq         uint32
+	CommandText string
+	RespChan    chan *CmdResponse
+	cancelled   bool
+}
+
+/* Commands which may be waiting to be written to the radio */
+const CMD_QUEUE_LEN = 16
+
+var ErrCmdQueueFull = errors.New("SmartAPIInterface: command queue full")
+var ErrAPILoopStopped = errors.New("SmartAPIInterface: API loop not running")
+
+type CommandHandler func([]string) (string, uint32)
+
+type StatusHandler func(uint32, string)
+
+type SmartAPIInterface struct {
+	/* Accessed atomically; kept first for 64-bit alignment */
+	unknownLines uint64
+	infoLock     sync.Mutex
+	handle       uint32
+	version      FlexVersion
+	gotHandle    bool
+	gotVersion   bool
+	handshake    chan int
+	/* Guarded by infoLock */
+	transcript *TranscriptRecorder
+	TcpConn    net.Conn
+	writeLock  sync.Mutex
+	quit       chan int
+	done       chan int
+	errs       chan error
+	cmdSend    chan *InflightCmd
+	cmdCancel  chan *InflightCmd
+	/* Only touched by InterfaceLoop */
+	cmdSeq       uint32
+	inflightCmds map[uint32]*InflightCmd
+	/* Handler tables, see apiDispatch.go */
+	handlerLock    sync.RWMutex
+	nextToken      HandlerToken
+	cmdHandlers    map[string]*cmdHandlerEntry
+	statusHandlers []*statusHandlerEntry
+	msgHandlers    []*msgHandlerEntry
+	/* Set once the loop has exited; no more handlers are accepted */
+	dispatchDone bool
+}
+
+func (vers *FlexVersion) String() string {
+	return fmt.Sprintf("%d.%d.%d.%d", vers.Major, vers.Minor, vers.DevA, vers.DevB)
+}
+
+/* Returns -1, 0 or 1 as vers is older than, the same as or newer than other */
+func (vers FlexVersion) Compare(other FlexVersion) int {
+	a := []int{vers.Major, vers.Minor, vers.DevA, vers.DevB}
+	b := []int{other.Major, other.Minor, other.DevA, other.DevB}
+	for i := range a {
+		if a[i] < b[i] {
+			return -1
+		}
+		if a[i] > b[i] {
+			return 1
+		}
+	}
+	return 0
+}
+
+/* Radio is running software older than the waveform supports */
+type VersionError struct {
+	Have FlexVersion
+	Need FlexVersion
+}
+
+func (e *VersionError) Error() string {
+	return fmt.Sprintf("radio version %s is older than required %s", e.Have.String(), e.Need.String())
+}
+
+/* Parse a dotted version string. Missing trailing components are zero */
+func ParseFlexVersion(versStr string) (FlexVersion, error) {
+	vers := FlexVersion{}
+	vsegs := strings.Split(strings.TrimSpace(versStr), ".")
+	if len(vsegs) > 4 {
+		return vers, fmt.Errorf("ParseFlexVersion: too many components in %q", versStr)
+	}
+	fields := []*int{&vers.Major, &vers.Minor, &vers.DevA, &vers.DevB}
+	for i, seg := range vsegs {
+		v, err := strconv.Atoi(seg)
+		if err != nil {
+			return FlexVersion{}, fmt.Errorf("ParseFlexVersion: bad component in %q", versStr)
+		}
+		*fields[i] = v
+	}
+	return vers, nil
+}
+
+func InitAPIInterface(connection net.Conn) (*SmartAPIInterface, error) {
+	iface := &SmartAPIInterface{
+		inflightCmds:   make(map[uint32]*InflightCmd),
+		TcpConn:        connection,
+		errs:           make(chan error, 1),
+		quit:           make(chan int),
+		done:           make(chan int),
+		handshake:      make(chan int),
+		cmdSend:        make(chan *InflightCmd, CMD_QUEUE_LEN),
+		cmdCancel:      make(chan *InflightCmd),
+		cmdSeq:         10,
+		cmdHandlers:    make(map[string]*cmdHandlerEntry),
+		statusHandlers: make([]*statusHandlerEntry, 0),
+	}
+	return iface, nil
+}
+
+/* Write a full line to the radio. Safe to call from any goroutine */
+func (tcpi *SmartAPIInterface) writeLine(line string) error {
+	tcpi.writeLock.Lock()
+	defer tcpi.writeLock.Unlock()
+	tcpi.recordLine(TRANSCRIPT_SENT, line)
+	n, err := io.WriteString(tcpi.TcpConn, line)
+	if n == 0 && err == nil {
+		return errors.New("TCP Socket Closed")
+	}
+	return err
+}
+
+/* Pass an error to whoever is watching errs, without blocking */
+func (tcpi *SmartAPIInterface) reportError(err error) {
+	select {
+	case tcpi.errs <- err:
+	default:
+	}
+}
+
+func newInflightCmd(command string) *InflightCmd {
+	return &InflightCmd{
+		Seq:         0,
+		CommandText: command,
+		RespChan:    make(chan *CmdResponse, 1),
+	}
+}
+
+/* Hand a command to the interface loop without blocking */
+func (tcpi *SmartAPIInterface) enqueueCommand(cmd *InflightCmd) error {
+	select {
+	case <-tcpi.done:
+		return ErrAPILoopStopped
+	default:
+	}
+	select {
+	case tcpi.cmdSend <- cmd:
+		return nil
+	default:
+		return ErrCmdQueueFull
+	}
+}
+
+/* Ask the interface loop to forget a command that nobody is waiting on */
+func (tcpi *SmartAPIInterface) cancelCommand(cmd *InflightCmd) {
+	select {
+	case tcpi.cmdCancel <- cmd:
+	case <-tcpi.done:
+	}
+}
+
+/* Release handshake waiters once both V and H have arrived. Must hold infoLock */
+func (tcpi *SmartAPIInterface) checkHandshakeLocked() {
+	if !tcpi.gotVersion || !tcpi.gotHandle {
+		return
+	}
+	select {
+	case <-tcpi.handshake:
+	default:
+		close(tcpi.handshake)
+	}
+}
+
+/* Client handle assigned by the radio */
+func (tcpi *SmartAPIInterface) Handle() uint32 {
+	tcpi.infoLock.Lock()
+	defer tcpi.infoLock.Unlock()
+	return tcpi.handle
+}
+
+/* Software version reported by the radio */
+func (tcpi *SmartAPIInterface) Version() FlexVersion {
+	tcpi.infoLock.Lock()
+	defer tcpi.infoLock.Unlock()
+	return tcpi.version
+}
+
+/* Block until the radio has sent both its version and our client handle */
+func (tcpi *SmartAPIInterface) WaitHandshake(ctx context.Context) error {
+	select {
+	case <-tcpi.handshake:
+		return nil
+	case <-tcpi.done:
+		return ErrAPILoopStopped
+	case <-ctx.Done():
+		return fmt.Errorf("WaitHandshake: %w", ctx.Err())
+	}
+}
+
+/*
+ * Start an API interface on conn and wait for the V/H handshake. If
+ * minVersion is not nil, radios running older software are rejected with
+ * a *VersionError. The interface takes ownership of conn, and closes it
+ * on failure.
+ */
+func ConnectAPI(ctx context.Context, conn net.Conn, minVersion *FlexVersion) (*SmartAPIInterface, error) {
+	return connectAPI(ctx, conn, minVersion, nil)
+}
+
+/* ConnectAPI, recording the session from the first line if rec is not nil */
+func connectAPI(ctx context.Context, conn net.Conn, minVersion *FlexVersion, rec *TranscriptRecorder) (*SmartAPIInterface, error) {
+	api, err := InitAPIInterface(conn)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	api.SetTranscript(rec)
+	go api.InterfaceLoop()
+	if err := api.WaitHandshake(ctx); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if minVersion != nil {
+		if vers := api.Version(); vers.Compare(*minVersion) < 0 {
+			conn.Close()
+			return nil, &VersionError{Have: vers, Need: *minVersion}
+		}
+	}
+	return api, nil
+}
+
+/*
+ * Queue a command and call callback once with the response, or with an
+ * error if ctx is done or the connection closes first. Returns an error
+ * if the command could not be queued, in which case callback isn't called.
+ */
+func (tcpi *SmartAPIInterface) SendCommandContext(ctx context.Context, command string, callback func(string, uint32, error)) error {
+	cmd := newInflightCmd(command)
+	if err := tcpi.enqueueCommand(cmd); err != nil {
+		return err
+	}
+	go func() {
+		select {
+		case <-ctx.Done():
+			tcpi.cancelCommand(cmd)
+			callback("", 0, fmt.Errorf("SendCommand: %w", ctx.Err()))
+		case <-tcpi.done:
+			callback("", 0, ErrAPILoopStopped)
+		case resp := <-cmd.RespChan:
+			callback(resp.RespStr, resp.Status, nil)
+		}
+	}()
+	return nil
+}
+
+/* Send a command and wait for the response until ctx is done */
+func (tcpi *SmartAPIInterface) DoCommandContext(ctx context.Context, command string) (string, uint32, error) {
+	cmd := newInflightCmd(command)
+	if err := tcpi.enqueueCommand(cmd); err != nil {
+		return "", 0, err
+	}
+	select {
+	case <-ctx.Done():
+		tcpi.cancelCommand(cmd)
+		return "", 0, fmt.Errorf("DoCommand: %w", ctx.Err())
+	case <-tcpi.done:
+		return "", 0, ErrAPILoopStopped
+	case resp := <-cmd.RespChan:
+		return resp.RespStr, resp.Status, nil
+	}
+}
+
+/*
+ * Send a command and wait for the response. A non-zero status from the
+ * radio is returned as a *CmdError
+ */
+func (tcpi *SmartAPIInterface) RunCommand(ctx context.Context, command string) (string, error) {
+	resp, status, err := tcpi.DoCommandContext(ctx, command)
+	if err != nil {
+		return "", err
+	}
+	return resp, StatusError(status, command, resp)
+}
+
+func (tcpi *SmartAPIInterface) SendCommand(command string, callback func(string, uint32), timeout time.Duration) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	/* Timeouts and closed connections are dropped, as they always were */
+	err := tcpi.SendCommandContext(ctx, command, func(resp string, status uint32, err error) {
+		cancel()
+		if err == nil {
+			callback(resp, status)
+		}
+	})
+	if err != nil {
+		cancel()
+	}
+}
+
+func (tcpi *SmartAPIInterface) DoCommand(command string, timeout time.Duration) (string, uint32, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return tcpi.DoCommandContext(ctx, command)
+}
+
+func (tcpi *SmartAPIInterface) InterfaceLoop() {
+	defer tcpi.stopDispatch()
+	defer close(tcpi.done)
+	lineChan := make(chan string)
+	tcpErr := make(chan error, 1)
+	go func() {
+		reader := bufio.NewReader(tcpi.TcpConn)
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				tcpErr <- err
+				return
+			}
+			tcpi.recordLine(TRANSCRIPT_RECEIVED, line)
+			/* Don't hang around once the loop has gone away */
+			select {
+			case lineChan <- line[:len(line)-1]:
+			case <-tcpi.done:
+				return
+			}
+		}
+	}()
+
+	for {
+		select {
+		case err := <-tcpErr:
+			tcpi.reportError(err)
+			return
+		case <-tcpi.quit:
+			return
+		case cmd := <-tcpi.cmdCancel:
+			/* Caller gave up; don't keep waiting for a response */
+			if cmd.Seq == 0 {
+				cmd.cancelled = true
+			} else if tcpi.inflightCmds[cmd.Seq] == cmd {
+				delete(tcpi.inflightCmds, cmd.Seq)
+			}
+		case cmd := <-tcpi.cmdSend:
+			if cmd.cancelled {
+				break
+			}
+			tcpi.cmdSeq++
+			seq := tcpi.cmdSeq
+			cmdWire := fmt.Sprintf("C%d|%s\n", seq, cmd.CommandText)
+			if err := tcpi.writeLine(cmdWire); err != nil {
+				tcpi.reportError(err)
+				return
+			}
+			cmd.Seq = seq
+			tcpi.inflightCmds[seq] = cmd
+		case line := <-lineChan:
+			line = strings.TrimRight(line, "\r")
+			if len(line) == 0 {
+				continue
+			}
+			rdchar := line[0]
+			switch rdchar {
+			//Parse version string
+			case 'V':
+				vers, err := ParseFlexVersion(line[1:])
+				if err == nil {
+					tcpi.infoLock.Lock()
+					tcpi.version = vers
+					tcpi.gotVersion = true
+					tcpi.checkHandshakeLocked()
+					tcpi.infoLock.Unlock()
+				}
+
+			case 'H':
+				handle, err := strconv.ParseUint(line[1:], 16, 32)
+				if err == nil {
+					tcpi.infoLock.Lock()
+					tcpi.handle = uint32(handle)
+					tcpi.gotHandle = true
+					tcpi.checkHandshakeLocked()
+					tcpi.infoLock.Unlock()
+				}
+			case 'R':
+				respsegs := strings.Split(line[1:], "|")
+				if len(respsegs) >= 2 {
+					respStr := ""
+					respSeq, _ := strconv.Atoi(respsegs[0])
+					respVal, _ := strconv.ParseUint(respsegs[1], 16, 32)
+					if len(respsegs) >= 3 {
+						respStr = respsegs[2]
+					}
+					cmd := tcpi.inflightCmds[uint32(respSeq)]
+					if cmd != nil {
+						delete(tcpi.inflightCmds, uint32(respSeq))
+						resp := &CmdResponse{respStr, uint32(respVal)}
+						select {
+						case cmd.RespChan <- resp:
+						default:
+						}
+					}
+				}
+			case 'C':
+				tcpi.handleCommand(line[1:])
+			case 'S':
+				tcpi.handleStatus(line[1:])
+			case 'M':
+				tcpi.handleMessage(line[1:])
+			default:
+				atomic.AddUint64(&tcpi.unknownLines, 1)
+			}
+		}
+	}
+
+}
+
+func (api *SmartAPIInterface) Close() {
+	select {
+	case api.quit <- 1:
+	default:
+	}
+	return
+}
+
+// Loop to send ping commands to radio
+func (api *SmartAPIInterface) PingLoop(frequency time.Duration) {
+	start := time.Now()
+	for {
+		elapsed := time.Since(start)
+		cmd := fmt.Sprintf("ping ms_timestamp=%f", float32(elapsed/time.Microsecond)/1000)
+		api.SendCommand(cmd, func(a string, b uint32) {}, time.Millisecond*100)
+		/* Stops with the interface loop, once Close has taken effect */
+		select {
+		case <-api.done:
+			return
+		case <-time.After(frequency):
+		}
+	}
+}
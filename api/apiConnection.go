@@ -0,0 +1,360 @@
+/* SPDX-License-Identifier: GPL-3.0
+ *
+ * Copyright (C) 2018 Brady O'Brien. All Rights Reserved.
+ *
+ * Supervisor which keeps a SmartAPIInterface connected to the radio,
+ * redialing and restoring the waveform session whenever the link drops
+ */
+
+package api
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"time"
+)
+
+type ConnState int
+
+const (
+	CONN_DISCONNECTED ConnState = iota
+	CONN_CONNECTING
+	CONN_CONNECTED
+	CONN_CLOSED
+)
+
+const DEFAULT_MIN_BACKOFF = 500 * time.Millisecond
+const DEFAULT_MAX_BACKOFF = 30 * time.Second
+const DEFAULT_HANDSHAKE_TIMEOUT = 5 * time.Second
+const DEFAULT_PING_INTERVAL = 10 * time.Second
+
+var ErrNotConnected = errors.New("ConnectionManager: not connected to radio")
+
+func (state ConnState) String() string {
+	switch state {
+	case CONN_DISCONNECTED:
+		return "disconnected"
+	case CONN_CONNECTING:
+		return "connecting"
+	case CONN_CONNECTED:
+		return "connected"
+	case CONN_CLOSED:
+		return "closed"
+	}
+	return "unknown"
+}
+
+type ConnectionManager struct {
+	Addr             string
+	MinBackoff       time.Duration
+	MaxBackoff       time.Duration
+	HandshakeTimeout time.Duration
+	PingInterval     time.Duration
+	/* State transitions, delivered without blocking the manager */
+	StateChanges chan ConnState
+	/* Errors which caused a session to be torn down */
+	Errors chan error
+	/* If set, every session is recorded here */
+	Transcript *TranscriptRecorder
+
+	lock          sync.Mutex
+	api           *SmartAPIInterface
+	state         ConnState
+	waveformCfg   *WaveformConfig
+	subscriptions []string
+	nextToken     HandlerToken
+	handlers      map[HandlerToken]*managedHandler
+	quit          chan int
+	/* All subscriptions have been sent on api; new ones go out directly */
+	subsRestored bool
+}
+
+/* Handler registered with the manager, re-armed on every new session */
+type managedHandler struct {
+	cmd           string
+	cmdHandler    CommandHandler
+	prefix        string
+	statusHandler StatusHandler
+	queueLen      int
+	policy        OverflowPolicy
+	msgHandler    MessageHandler
+	/* Token of the registration on the current session's interface */
+	apiToken HandlerToken
+}
+
+/*
+ * Create a manager for the radio API at addr. waveformCfg is replayed on
+ * every connect and its minimum version enforced; it may be nil.
+ */
+func NewConnectionManager(addr string, waveformCfg *WaveformConfig) *ConnectionManager {
+	return &ConnectionManager{
+		Addr:             addr,
+		MinBackoff:       DEFAULT_MIN_BACKOFF,
+		MaxBackoff:       DEFAULT_MAX_BACKOFF,
+		HandshakeTimeout: DEFAULT_HANDSHAKE_TIMEOUT,
+		PingInterval:     DEFAULT_PING_INTERVAL,
+		StateChanges:     make(chan ConnState, 8),
+		Errors:           make(chan error, 8),
+		waveformCfg:      waveformCfg,
+		handlers:         make(map[HandlerToken]*managedHandler),
+		quit:             make(chan int),
+	}
+}
+
+/* Add a subscription (e.g. "sub slice all") issued on every connect */
+func (mgr *ConnectionManager) Subscribe(cmd string) {
+	mgr.lock.Lock()
+	defer mgr.lock.Unlock()
+	mgr.subscriptions = append(mgr.subscriptions, cmd)
+	if mgr.api != nil && mgr.subsRestored {
+		mgr.api.SendCommand(cmd, func(string, uint32) {}, time.Second)
+	}
+}
+
+/* Register with api. Must hold mgr.lock */
+func (mh *managedHandler) arm(api *SmartAPIInterface) {
+	switch {
+	case mh.cmdHandler != nil:
+		mh.apiToken = api.RegisterCommandHandler(mh.cmd, mh.cmdHandler)
+	case mh.statusHandler != nil:
+		mh.apiToken = api.RegisterStatusHandlerQueued(mh.prefix, mh.statusHandler, mh.queueLen, mh.policy)
+	case mh.msgHandler != nil:
+		mh.apiToken = api.SubscribeMessages(mh.msgHandler)
+	}
+}
+
+func (mgr *ConnectionManager) addHandler(mh *managedHandler) HandlerToken {
+	mgr.lock.Lock()
+	defer mgr.lock.Unlock()
+	mgr.nextToken++
+	mgr.handlers[mgr.nextToken] = mh
+	if mgr.api != nil {
+		mh.arm(mgr.api)
+	}
+	return mgr.nextToken
+}
+
+/* Register a command handler which survives reconnects */
+func (mgr *ConnectionManager) RegisterCommandHandler(cmd string, handler CommandHandler) HandlerToken {
+	return mgr.addHandler(&managedHandler{cmd: cmd, cmdHandler: handler})
+}
+
+/* Register a status handler which survives reconnects */
+func (mgr *ConnectionManager) RegisterStatusHandler(prefix string, handler StatusHandler) HandlerToken {
+	return mgr.RegisterStatusHandlerQueued(prefix, handler, DEFAULT_HANDLER_QUEUE_LEN, OVERFLOW_DROP_OLDEST)
+}
+
+/* As SmartAPIInterface.RegisterStatusHandlerQueued, kept across reconnects */
+func (mgr *ConnectionManager) RegisterStatusHandlerQueued(prefix string, handler StatusHandler, queueLen int, policy OverflowPolicy) HandlerToken {
+	return mgr.addHandler(&managedHandler{prefix: prefix, statusHandler: handler, queueLen: queueLen, policy: policy})
+}
+
+/* Subscribe to radio messages across reconnects */
+func (mgr *ConnectionManager) SubscribeMessages(handler MessageHandler) HandlerToken {
+	return mgr.addHandler(&managedHandler{msgHandler: handler})
+}
+
+/* Remove a handler registered with the manager */
+func (mgr *ConnectionManager) Unregister(token HandlerToken) bool {
+	mgr.lock.Lock()
+	defer mgr.lock.Unlock()
+	mh, ok := mgr.handlers[token]
+	if !ok {
+		return false
+	}
+	delete(mgr.handlers, token)
+	if mgr.api != nil {
+		mgr.api.Unregister(mh.apiToken)
+	}
+	return true
+}
+
+/* Current API interface, or nil while disconnected */
+func (mgr *ConnectionManager) API() *SmartAPIInterface {
+	mgr.lock.Lock()
+	defer mgr.lock.Unlock()
+	if mgr.state != CONN_CONNECTED {
+		return nil
+	}
+	return mgr.api
+}
+
+func (mgr *ConnectionManager) State() ConnState {
+	mgr.lock.Lock()
+	defer mgr.lock.Unlock()
+	return mgr.state
+}
+
+/* Run a command on the current connection */
+func (mgr *ConnectionManager) DoCommandContext(ctx context.Context, command string) (string, uint32, error) {
+	api := mgr.API()
+	if api == nil {
+		return "", 0, ErrNotConnected
+	}
+	return api.DoCommandContext(ctx, command)
+}
+
+/* Run a command on the current connection, returning radio errors as a *CmdError */
+func (mgr *ConnectionManager) RunCommand(ctx context.Context, command string) (string, error) {
+	api := mgr.API()
+	if api == nil {
+		return "", ErrNotConnected
+	}
+	return api.RunCommand(ctx, command)
+}
+
+/* Send "waveform remove" for the configured waveform, if there is one */
+func (mgr *ConnectionManager) RemoveWaveform(ctx context.Context) error {
+	if mgr.waveformCfg == nil {
+		return nil
+	}
+	_, err := mgr.RunCommand(ctx, "waveform remove "+mgr.waveformCfg.Name)
+	return err
+}
+
+func (mgr *ConnectionManager) setState(state ConnState) {
+	mgr.lock.Lock()
+	mgr.state = state
+	mgr.lock.Unlock()
+	select {
+	case mgr.StateChanges <- state:
+	default:
+	}
+}
+
+/* Pass err on to Errors, without blocking */
+func (mgr *ConnectionManager) ReportError(err error) {
+	select {
+	case mgr.Errors <- err:
+	default:
+	}
+}
+
+/* Re-arm handlers, register the waveform and restore subscriptions */
+func (mgr *ConnectionManager) restoreSession(api *SmartAPIInterface) error {
+	mgr.lock.Lock()
+	for _, mh := range mgr.handlers {
+		mh.arm(api)
+	}
+	mgr.api = api
+	mgr.lock.Unlock()
+
+	if mgr.waveformCfg != nil {
+		if err := RegisterWaveformConfig(api, mgr.waveformCfg); err != nil {
+			return err
+		}
+	}
+	/*
+	 * Subscribe may add to the list while we replay it. Go until we've
+	 * caught up, and only then let Subscribe send by itself.
+	 */
+	for sent := 0; ; sent++ {
+		mgr.lock.Lock()
+		if sent == len(mgr.subscriptions) {
+			mgr.subsRestored = true
+			mgr.lock.Unlock()
+			return nil
+		}
+		cmd := mgr.subscriptions[sent]
+		mgr.lock.Unlock()
+		ctx, cancel := context.WithTimeout(context.Background(), mgr.HandshakeTimeout)
+		_, err := api.RunCommand(ctx, cmd)
+		cancel()
+		if err != nil {
+			return err
+		}
+	}
+}
+
+/* Dial the radio and run one session until it fails or the manager is closed */
+func (mgr *ConnectionManager) runSession() error {
+	conn, err := net.DialTimeout("tcp", mgr.Addr, mgr.HandshakeTimeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	var minVersion *FlexVersion
+	if mgr.waveformCfg != nil {
+		minVersion = &mgr.waveformCfg.MinVersion
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), mgr.HandshakeTimeout)
+	api, err := connectAPI(ctx, conn, minVersion, mgr.Transcript)
+	cancel()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		mgr.lock.Lock()
+		mgr.api = nil
+		mgr.subsRestored = false
+		mgr.lock.Unlock()
+	}()
+
+	if err := mgr.restoreSession(api); err != nil {
+		return err
+	}
+	go api.PingLoop(mgr.PingInterval)
+	mgr.setState(CONN_CONNECTED)
+
+	select {
+	case err := <-api.errs:
+		return err
+	case <-api.done:
+		return ErrAPILoopStopped
+	case <-mgr.quit:
+		return nil
+	}
+}
+
+/*
+ * Keep the radio connected until Close is called. Returns nil after Close,
+ * or the error that makes reconnecting pointless.
+ */
+func (mgr *ConnectionManager) Run() error {
+	backoff := mgr.MinBackoff
+	for {
+		mgr.setState(CONN_CONNECTING)
+		err := mgr.runSession()
+		select {
+		case <-mgr.quit:
+			mgr.setState(CONN_CLOSED)
+			return nil
+		default:
+		}
+		if mgr.State() == CONN_CONNECTED {
+			/* We got a working session, so start backing off from scratch */
+			backoff = mgr.MinBackoff
+		}
+		mgr.setState(CONN_DISCONNECTED)
+		if err != nil {
+			mgr.ReportError(err)
+		}
+		/* Redialing won't make the radio any newer */
+		var versErr *VersionError
+		if errors.As(err, &versErr) {
+			mgr.setState(CONN_CLOSED)
+			return err
+		}
+		select {
+		case <-mgr.quit:
+			mgr.setState(CONN_CLOSED)
+			return nil
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+		if backoff > mgr.MaxBackoff {
+			backoff = mgr.MaxBackoff
+		}
+	}
+}
+
+func (mgr *ConnectionManager) Close() {
+	select {
+	case <-mgr.quit:
+		return
+	default:
+	}
+	close(mgr.quit)
+}
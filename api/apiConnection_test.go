@@ -0,0 +1,198 @@
+/* SPDX-License-Identifier: GPL-3.0
+ *
+ * Copyright (C) 2018 Brady O'Brien. All Rights Reserved.
+ */
+
+package api
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func waitState(t *testing.T, mgr *ConnectionManager, want ConnState) {
+	t.Helper()
+	timeout := time.After(testTimeout)
+	for {
+		select {
+		case state := <-mgr.StateChanges:
+			if state == want {
+				return
+			}
+		case <-timeout:
+			t.Fatalf("manager never reached %s", want.String())
+		}
+	}
+}
+
+func countReceived(mock *MockRadio, cmd string) int {
+	n := 0
+	for _, received := range mock.Received() {
+		if received == cmd {
+			n++
+		}
+	}
+	return n
+}
+
+/* Dropping the link redials, and replays the waveform, subscriptions and handlers */
+func TestReconnectRestoresSession(t *testing.T) {
+	cfg, err := ParseWaveformConfig(strings.NewReader(testWaveformCfg))
+	if err != nil {
+		t.Fatalf("ParseWaveformConfig: %v", err)
+	}
+	mock := NewMockRadio(testVersion, 1)
+	addr, err := mock.Listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	defer mock.Close()
+
+	mgr := NewConnectionManager(addr.String(), cfg)
+	mgr.MinBackoff = 10 * time.Millisecond
+	mgr.Subscribe("sub meter all")
+	sliceStatus := make(chan string, 8)
+	mgr.RegisterStatusHandler("slice ", func(handle uint32, status string) {
+		sliceStatus <- status
+	})
+	mgr.RegisterCommandHandler("slice", func(argv []string) (string, uint32) {
+		return "ok", 0
+	})
+	runErr := make(chan error, 1)
+	go func() {
+		runErr <- mgr.Run()
+	}()
+
+	for session := 1; session <= 2; session++ {
+		waitState(t, mgr, CONN_CONNECTED)
+		for _, cmd := range append(append([]string(nil), cfg.SetupCommands...), "sub meter all") {
+			if n := countReceived(mock, cmd); n != session {
+				t.Errorf("session %d: %q sent %d times", session, cmd, n)
+			}
+		}
+		mock.PushStatus(1, "slice 0 mode=FDV")
+		if got := recvString(t, sliceStatus); got != "slice 0 mode=FDV" {
+			t.Errorf("session %d: status handler got %q", session, got)
+		}
+		if session == 1 {
+			mock.Drop()
+			waitState(t, mgr, CONN_DISCONNECTED)
+		}
+	}
+
+	mgr.Close()
+	select {
+	case err := <-runErr:
+		if err != nil {
+			t.Errorf("Run returned %v after Close", err)
+		}
+	case <-time.After(testTimeout):
+		t.Fatal("Run did not return after Close")
+	}
+}
+
+func TestRunStopsOnOldRadio(t *testing.T) {
+	cfg, err := ParseWaveformConfig(strings.NewReader(testWaveformCfg))
+	if err != nil {
+		t.Fatalf("ParseWaveformConfig: %v", err)
+	}
+	mock := NewMockRadio(FlexVersion{1, 4, 0, 0}, 1)
+	addr, err := mock.Listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	defer mock.Close()
+
+	mgr := NewConnectionManager(addr.String(), cfg)
+	mgr.MinBackoff = 10 * time.Millisecond
+	err = mgr.Run()
+	if _, ok := err.(*VersionError); !ok {
+		t.Errorf("Run returned %v, want *VersionError", err)
+	}
+}
+
+/* A subscription made while a restore is replaying goes out in that session */
+func TestSubscribeDuringRestore(t *testing.T) {
+	mock := NewMockRadio(testVersion, 1)
+	replaying := make(chan int)
+	release := make(chan int)
+	mock.RespondFunc("sub slice all", func(string) (uint32, string) {
+		close(replaying)
+		<-release
+		return 0, ""
+	})
+	addr, err := mock.Listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	defer mock.Close()
+
+	mgr := NewConnectionManager(addr.String(), nil)
+	mgr.Subscribe("sub slice all")
+	runErr := make(chan error, 1)
+	go func() {
+		runErr <- mgr.Run()
+	}()
+	select {
+	case <-replaying:
+	case <-time.After(testTimeout):
+		t.Fatal("restore never replayed the first subscription")
+	}
+	mgr.Subscribe("sub meter all")
+	close(release)
+
+	ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+	defer cancel()
+	if _, err := mock.WaitForCommand(ctx, "sub meter all"); err != nil {
+		t.Fatal(err)
+	}
+	waitState(t, mgr, CONN_CONNECTED)
+	if n := countReceived(mock, "sub meter all"); n != 1 {
+		t.Errorf("subscription sent %d times", n)
+	}
+	/* Once connected, subscriptions go straight out */
+	mgr.Subscribe("sub tx all")
+	if _, err := mock.WaitForCommand(ctx, "sub tx all"); err != nil {
+		t.Fatal(err)
+	}
+	mgr.Close()
+	<-runErr
+}
+
+/* A radio refusing the waveform fails the session rather than leaving it half set up */
+func TestRestoreFailsOnRejectedWaveform(t *testing.T) {
+	cfg, err := ParseWaveformConfig(strings.NewReader(testWaveformCfg))
+	if err != nil {
+		t.Fatalf("ParseWaveformConfig: %v", err)
+	}
+	mock := NewMockRadio(testVersion, 1)
+	mock.Respond("waveform create", SL_NOT_PERMITTED, "")
+	addr, err := mock.Listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	defer mock.Close()
+
+	mgr := NewConnectionManager(addr.String(), cfg)
+	mgr.MinBackoff = time.Second
+	runErr := make(chan error, 1)
+	go func() {
+		runErr <- mgr.Run()
+	}()
+	select {
+	case err := <-mgr.Errors:
+		if !errors.Is(err, SL_NOT_PERMITTED) {
+			t.Errorf("session failed with %v, want SL_NOT_PERMITTED", err)
+		}
+	case <-time.After(testTimeout):
+		t.Fatal("session with a rejected waveform didn't fail")
+	}
+	if mgr.State() == CONN_CONNECTED {
+		t.Error("manager connected despite the rejected waveform")
+	}
+	mgr.Close()
+	<-runErr
+}
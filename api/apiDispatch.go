@@ -0,0 +1,360 @@
+/* SPDX-License-Identifier: GPL-3.0
+ *
+ * Copyright (C) 2018 Brady O'Brien. All Rights Reserved.
+ *
+ * Handler tables for radio-originated commands and status, and the
+ * queues which keep slow handlers from stalling the API read loop
+ */
+
+package api
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+/* Identifies a registered handler so it can be removed again */
+type HandlerToken uint64
+
+/* What to do when a handler's queue is full */
+type OverflowPolicy int
+
+const (
+	/* Throw away the oldest queued item to make room */
+	OVERFLOW_DROP_OLDEST OverflowPolicy = iota
+	/* Throw away the item that didn't fit */
+	OVERFLOW_DROP_NEWEST
+	/* Wait for room. This stalls the read loop until the handler catches up */
+	OVERFLOW_BLOCK
+)
+
+const DEFAULT_HANDLER_QUEUE_LEN = 64
+
+/* Work queue for one handler, drained by its own goroutine */
+type dispatchQueue struct {
+	queue  chan func()
+	policy OverflowPolicy
+	/* Run whatever is still queued once stopped, rather than drop it */
+	drain bool
+	quit  chan int
+	/* Held shared by push, so run can wait out pushes racing with stop */
+	pushLock sync.RWMutex
+	dropped  uint64
+}
+
+type cmdHandlerEntry struct {
+	token   HandlerToken
+	handler CommandHandler
+	queue   *dispatchQueue
+}
+
+type statusHandlerEntry struct {
+	token   HandlerToken
+	prefix  string
+	handler StatusHandler
+	queue   *dispatchQueue
+}
+
+func newDispatchQueue(queueLen int, policy OverflowPolicy) *dispatchQueue {
+	if queueLen <= 0 {
+		queueLen = DEFAULT_HANDLER_QUEUE_LEN
+	}
+	q := &dispatchQueue{
+		queue:  make(chan func(), queueLen),
+		policy: policy,
+		quit:   make(chan int),
+	}
+	go q.run()
+	return q
+}
+
+/* Queue for radio commands: never drops, and runs what's queued when stopped */
+func newCommandQueue() *dispatchQueue {
+	q := &dispatchQueue{
+		queue:  make(chan func(), DEFAULT_HANDLER_QUEUE_LEN),
+		policy: OVERFLOW_BLOCK,
+		drain:  true,
+		quit:   make(chan int),
+	}
+	go q.run()
+	return q
+}
+
+func (q *dispatchQueue) run() {
+	for {
+		select {
+		case <-q.quit:
+			if q.drain {
+				/* Pushes which got in before stop have finished once we get the lock */
+				q.pushLock.Lock()
+				q.pushLock.Unlock()
+				for {
+					select {
+					case fn := <-q.queue:
+						fn()
+					default:
+						return
+					}
+				}
+			}
+			return
+		case fn := <-q.queue:
+			fn()
+		}
+	}
+}
+
+/*
+ * Queue fn, applying the overflow policy. Returns false if fn was not
+ * queued because the queue has been stopped; fn dropped by the policy
+ * counts as queued.
+ */
+func (q *dispatchQueue) push(fn func()) bool {
+	q.pushLock.RLock()
+	defer q.pushLock.RUnlock()
+	select {
+	case <-q.quit:
+		return false
+	default:
+	}
+	for {
+		select {
+		case q.queue <- fn:
+			return true
+		case <-q.quit:
+			return false
+		default:
+		}
+		switch q.policy {
+		case OVERFLOW_DROP_NEWEST:
+			atomic.AddUint64(&q.dropped, 1)
+			return true
+		case OVERFLOW_DROP_OLDEST:
+			select {
+			case <-q.queue:
+				atomic.AddUint64(&q.dropped, 1)
+			default:
+			}
+		case OVERFLOW_BLOCK:
+			select {
+			case q.queue <- fn:
+				return true
+			case <-q.quit:
+				return false
+			}
+		}
+	}
+}
+
+func (q *dispatchQueue) stop() {
+	select {
+	case <-q.quit:
+	default:
+		close(q.quit)
+	}
+}
+
+/*
+ * Number of status lines or messages thrown away because the handler for
+ * token fell behind
+ */
+func (tcpi *SmartAPIInterface) HandlerDropped(token HandlerToken) uint64 {
+	tcpi.handlerLock.RLock()
+	defer tcpi.handlerLock.RUnlock()
+	for _, entry := range tcpi.statusHandlers {
+		if entry.token == token {
+			return atomic.LoadUint64(&entry.queue.dropped)
+		}
+	}
+	for _, entry := range tcpi.msgHandlers {
+		if entry.token == token {
+			return atomic.LoadUint64(&entry.queue.dropped)
+		}
+	}
+	return 0
+}
+
+func (tcpi *SmartAPIInterface) newTokenLocked() HandlerToken {
+	tcpi.nextToken++
+	return tcpi.nextToken
+}
+
+/*
+ * Register handler for radio commands whose first word is cmd, replacing
+ * any existing handler for cmd. Commands run one at a time on the
+ * handler's own goroutine, so responses go back in the order commands
+ * arrived. A full queue stalls the read loop rather than drop a command.
+ * Commands already queued for a replaced handler still run on it, and
+ * any that miss the queue are answered with SL_BAD_COMMAND, so every
+ * command gets a response. Returns 0 once the interface loop has stopped.
+ */
+func (tcpi *SmartAPIInterface) RegisterCommandHandler(cmd string, handler CommandHandler) HandlerToken {
+	tcpi.handlerLock.Lock()
+	defer tcpi.handlerLock.Unlock()
+	if tcpi.dispatchDone {
+		return 0
+	}
+	if old, ok := tcpi.cmdHandlers[cmd]; ok {
+		old.queue.stop()
+	}
+	entry := &cmdHandlerEntry{
+		token:   tcpi.newTokenLocked(),
+		handler: handler,
+		queue:   newCommandQueue(),
+	}
+	tcpi.cmdHandlers[cmd] = entry
+	return entry.token
+}
+
+/* Register a status handler with the default queue length and policy */
+func (tcpi *SmartAPIInterface) RegisterStatusHandler(prefix string, handler StatusHandler) HandlerToken {
+	return tcpi.RegisterStatusHandlerQueued(prefix, handler, DEFAULT_HANDLER_QUEUE_LEN, OVERFLOW_DROP_OLDEST)
+}
+
+/*
+ * Register handler for status lines starting with prefix. Status is
+ * delivered in order through a queue of queueLen entries; policy decides
+ * what happens when the handler falls behind. Returns 0 once the
+ * interface loop has stopped.
+ */
+func (tcpi *SmartAPIInterface) RegisterStatusHandlerQueued(prefix string, handler StatusHandler, queueLen int, policy OverflowPolicy) HandlerToken {
+	tcpi.handlerLock.Lock()
+	defer tcpi.handlerLock.Unlock()
+	if tcpi.dispatchDone {
+		return 0
+	}
+	entry := &statusHandlerEntry{
+		token:   tcpi.newTokenLocked(),
+		prefix:  prefix,
+		handler: handler,
+		queue:   newDispatchQueue(queueLen, policy),
+	}
+	/* Copy on write so dispatch can iterate without holding the lock */
+	handlers := make([]*statusHandlerEntry, len(tcpi.statusHandlers), len(tcpi.statusHandlers)+1)
+	copy(handlers, tcpi.statusHandlers)
+	tcpi.statusHandlers = append(handlers, entry)
+	return entry.token
+}
+
+/* Remove a handler. Returns false if token was not registered */
+func (tcpi *SmartAPIInterface) Unregister(token HandlerToken) bool {
+	tcpi.handlerLock.Lock()
+	defer tcpi.handlerLock.Unlock()
+	for cmd, entry := range tcpi.cmdHandlers {
+		if entry.token == token {
+			delete(tcpi.cmdHandlers, cmd)
+			entry.queue.stop()
+			return true
+		}
+	}
+	for i, entry := range tcpi.statusHandlers {
+		if entry.token == token {
+			handlers := make([]*statusHandlerEntry, 0, len(tcpi.statusHandlers)-1)
+			handlers = append(handlers, tcpi.statusHandlers[:i]...)
+			tcpi.statusHandlers = append(handlers, tcpi.statusHandlers[i+1:]...)
+			entry.queue.stop()
+			return true
+		}
+	}
+	for i, entry := range tcpi.msgHandlers {
+		if entry.token == token {
+			handlers := make([]*msgHandlerEntry, 0, len(tcpi.msgHandlers)-1)
+			handlers = append(handlers, tcpi.msgHandlers[:i]...)
+			tcpi.msgHandlers = append(handlers, tcpi.msgHandlers[i+1:]...)
+			entry.queue.stop()
+			return true
+		}
+	}
+	return false
+}
+
+/*
+ * Stop every handler queue, and refuse new handlers from now on. Called
+ * once the interface loop exits
+ */
+func (tcpi *SmartAPIInterface) stopDispatch() {
+	tcpi.handlerLock.Lock()
+	defer tcpi.handlerLock.Unlock()
+	tcpi.dispatchDone = true
+	for _, entry := range tcpi.cmdHandlers {
+		entry.queue.stop()
+	}
+	for _, entry := range tcpi.statusHandlers {
+		entry.queue.stop()
+	}
+	for _, entry := range tcpi.msgHandlers {
+		entry.queue.stop()
+	}
+}
+
+func (tcpi *SmartAPIInterface) runCommandHandler(cmdSeq int, handler CommandHandler, argv []string) {
+	var respVal uint32
+	var respStr string
+	if handler != nil {
+		respStr, respVal = handler(argv)
+	} else {
+		respStr = ""
+		respVal = uint32(SL_BAD_COMMAND)
+	}
+	respWire := fmt.Sprintf("R%d|%x|%s\n", cmdSeq, respVal, respStr)
+	if err := tcpi.writeLine(respWire); err != nil {
+		tcpi.reportError(err)
+	}
+}
+
+func (tcpi *SmartAPIInterface) handleCommand(cmdStr string) {
+	cmdSegs := strings.Split(cmdStr, "|")
+	if len(cmdSegs) >= 2 {
+		cmdSeq, err := strconv.Atoi(cmdSegs[0])
+		if err != nil {
+			return
+		}
+		fullCmd := cmdSegs[1]
+		argv := strings.Split(fullCmd, " ")
+		if len(argv) >= 1 {
+			tcpi.handlerLock.RLock()
+			entry, ok := tcpi.cmdHandlers[argv[0]]
+			tcpi.handlerLock.RUnlock()
+			if !ok {
+				tcpi.runCommandHandler(cmdSeq, nil, argv)
+				return
+			}
+			handler := entry.handler
+			queued := entry.queue.push(func() {
+				tcpi.runCommandHandler(cmdSeq, handler, argv)
+			})
+			if !queued {
+				/* The handler went away under us; answer as if it never was */
+				tcpi.runCommandHandler(cmdSeq, nil, argv)
+			}
+		}
+	}
+}
+
+func (tcpi *SmartAPIInterface) handleStatus(status string) {
+	statSeg := strings.Split(status, "|")
+	if len(statSeg) >= 2 {
+		if len(statSeg[1]) <= 0 {
+			return
+		}
+		statStr := statSeg[1]
+		idHandler, err := strconv.ParseUint(statSeg[0], 16, 32)
+		if err != nil {
+			return
+		}
+		tcpi.handlerLock.RLock()
+		handlers := tcpi.statusHandlers
+		tcpi.handlerLock.RUnlock()
+		for _, entry := range handlers {
+			if strings.HasPrefix(statStr, entry.prefix) {
+				handler := entry.handler
+				entry.queue.push(func() {
+					handler(uint32(idHandler), statStr)
+				})
+			}
+		}
+	}
+}
@@ -0,0 +1,439 @@
+/* SPDX-License-Identifier: GPL-3.0
+ *
+ * Copyright (C) 2018 Brady O'Brien. All Rights Reserved.
+ */
+
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const testTimeout = 2 * time.Second
+
+var testVersion = FlexVersion{2, 4, 9, 0}
+
+/* Connect an interface to mock over a pipe, torn down when the test ends */
+func connectMock(t *testing.T, mock *MockRadio) *SmartAPIInterface {
+	t.Helper()
+	conn := mock.Pipe()
+	ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+	defer cancel()
+	api, err := ConnectAPI(ctx, conn, nil)
+	if err != nil {
+		t.Fatalf("ConnectAPI: %v", err)
+	}
+	t.Cleanup(func() {
+		conn.Close()
+		<-api.done
+	})
+	return api
+}
+
+func TestConnectHandshake(t *testing.T) {
+	mock := NewMockRadio(testVersion, 0x1234ABCD)
+	api := connectMock(t, mock)
+	if api.Handle() != 0x1234ABCD {
+		t.Errorf("handle %08X, want 1234ABCD", api.Handle())
+	}
+	if vers := api.Version(); vers != testVersion {
+		t.Errorf("version %s, want %s", vers.String(), testVersion.String())
+	}
+}
+
+func TestConnectRejectsOldVersion(t *testing.T) {
+	mock := NewMockRadio(FlexVersion{1, 4, 0, 0}, 1)
+	ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+	defer cancel()
+	_, err := ConnectAPI(ctx, mock.Pipe(), &testVersion)
+	if _, ok := err.(*VersionError); !ok {
+		t.Fatalf("ConnectAPI error %v, want *VersionError", err)
+	}
+}
+
+/*
+ * Several commands in flight at once, with status and radio commands
+ * going the other way, must all complete
+ */
+func TestPipelinedCommands(t *testing.T) {
+	mock := NewMockRadio(testVersion, 1)
+	mock.RespondFunc("echo ", func(cmd string) (uint32, string) {
+		return 0, strings.TrimPrefix(cmd, "echo ")
+	})
+	api := connectMock(t, mock)
+	api.RegisterCommandHandler("ping", func(argv []string) (string, uint32) {
+		return "pong", 0
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+	defer cancel()
+	var wg sync.WaitGroup
+	errs := make(chan error, 2*CMD_QUEUE_LEN)
+	for i := 0; i < CMD_QUEUE_LEN; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			want := fmt.Sprintf("%d", i)
+			resp, status, err := api.DoCommandContext(ctx, "echo "+want)
+			if err != nil || status != 0 || resp != want {
+				errs <- fmt.Errorf("echo %d: %q %x %v", i, resp, status, err)
+			}
+		}(i)
+		go func(i int) {
+			defer wg.Done()
+			mock.PushStatus(1, fmt.Sprintf("slice %d in_use=1", i))
+			resp, _, err := mock.SendCommand(ctx, "ping")
+			if err != nil || resp != "pong" {
+				errs <- fmt.Errorf("ping %d: %q %v", i, resp, err)
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+}
+
+func TestCommandHandlerDispatch(t *testing.T) {
+	mock := NewMockRadio(testVersion, 1)
+	api := connectMock(t, mock)
+
+	var running, overlapped int32
+	var lock sync.Mutex
+	var order []string
+	api.RegisterCommandHandler("slice", func(argv []string) (string, uint32) {
+		if atomic.AddInt32(&running, 1) > 1 {
+			atomic.StoreInt32(&overlapped, 1)
+		}
+		time.Sleep(time.Millisecond)
+		lock.Lock()
+		order = append(order, argv[1])
+		lock.Unlock()
+		atomic.AddInt32(&running, -1)
+		return "done " + argv[1], 0
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+	defer cancel()
+	resp, status, err := mock.SendCommand(ctx, "slice 0 waveform_cmd mode=700C")
+	if err != nil || status != 0 || resp != "done 0" {
+		t.Fatalf("slice command: %q %x %v", resp, status, err)
+	}
+	_, status, err = mock.SendCommand(ctx, "display pan")
+	if err != nil || status != uint32(SL_BAD_COMMAND) {
+		t.Errorf("unhandled command: status %x %v, want %x", status, err, uint32(SL_BAD_COMMAND))
+	}
+
+	/*
+	 * Commands for one handler run one at a time, in arrival order. The
+	 * last one is answered only after all those queued ahead of it
+	 */
+	for i := 1; i <= 8; i++ {
+		mock.PushLine(fmt.Sprintf("C%d|slice %d", 100+i, i))
+	}
+	if _, _, err := mock.SendCommand(ctx, "slice 9"); err != nil {
+		t.Fatalf("slice 9: %v", err)
+	}
+	lock.Lock()
+	defer lock.Unlock()
+	want := []string{"0", "1", "2", "3", "4", "5", "6", "7", "8", "9"}
+	if strings.Join(order, ",") != strings.Join(want, ",") {
+		t.Errorf("handler order %v, want %v", order, want)
+	}
+	if atomic.LoadInt32(&overlapped) != 0 {
+		t.Error("command handler ran concurrently with itself")
+	}
+}
+
+func TestStatusHandlerDispatch(t *testing.T) {
+	mock := NewMockRadio(testVersion, 1)
+	api := connectMock(t, mock)
+
+	sliceStatus := make(chan string, 8)
+	allStatus := make(chan string, 8)
+	api.RegisterStatusHandler("slice ", func(handle uint32, status string) {
+		sliceStatus <- fmt.Sprintf("%X %s", handle, status)
+	})
+	api.RegisterStatusHandler("", func(handle uint32, status string) {
+		allStatus <- status
+	})
+
+	mock.PushStatus(0x42, "slice 0 mode=USB")
+	mock.PushStatus(0x42, "radio slices=4")
+	mock.PushStatus(0x43, "slice 1 mode=FDV")
+
+	for _, want := range []string{"slice 0 mode=USB", "radio slices=4", "slice 1 mode=FDV"} {
+		if got := recvString(t, allStatus); got != want {
+			t.Errorf("catch-all handler got %q, want %q", got, want)
+		}
+	}
+	for _, want := range []string{"42 slice 0 mode=USB", "43 slice 1 mode=FDV"} {
+		if got := recvString(t, sliceStatus); got != want {
+			t.Errorf("slice handler got %q, want %q", got, want)
+		}
+	}
+	select {
+	case extra := <-sliceStatus:
+		t.Errorf("slice handler got unexpected %q", extra)
+	default:
+	}
+}
+
+func TestMessageDispatch(t *testing.T) {
+	mock := NewMockRadio(testVersion, 1)
+	api := connectMock(t, mock)
+	msgs := make(chan *RadioMessage, 1)
+	api.SubscribeMessages(func(msg *RadioMessage) {
+		msgs <- msg
+	})
+	mock.PushMessage(0x02000010, "Transmit inhibited")
+	select {
+	case msg := <-msgs:
+		if msg.Severity != MSG_ERROR || msg.Text != "Transmit inhibited" {
+			t.Errorf("got message %s", msg.String())
+		}
+	case <-time.After(testTimeout):
+		t.Fatal("no message delivered")
+	}
+}
+
+func TestUnregister(t *testing.T) {
+	mock := NewMockRadio(testVersion, 1)
+	api := connectMock(t, mock)
+
+	removed := make(chan string, 8)
+	barrier := make(chan string, 8)
+	token := api.RegisterStatusHandler("", func(handle uint32, status string) {
+		removed <- status
+	})
+	api.RegisterStatusHandler("", func(handle uint32, status string) {
+		barrier <- status
+	})
+	cmdToken := api.RegisterCommandHandler("slice", func([]string) (string, uint32) {
+		return "", 0
+	})
+
+	if !api.Unregister(token) {
+		t.Fatal("Unregister of a status handler returned false")
+	}
+	if api.Unregister(token) {
+		t.Error("second Unregister returned true")
+	}
+	if !api.Unregister(cmdToken) {
+		t.Fatal("Unregister of a command handler returned false")
+	}
+
+	mock.PushStatus(1, "slice 0 mode=USB")
+	recvString(t, barrier)
+	select {
+	case status := <-removed:
+		t.Errorf("unregistered handler got %q", status)
+	default:
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+	defer cancel()
+	_, status, err := mock.SendCommand(ctx, "slice 0 waveform_cmd mode=700C")
+	if err != nil || status != uint32(SL_BAD_COMMAND) {
+		t.Errorf("unregistered command: status %x %v, want %x", status, err, uint32(SL_BAD_COMMAND))
+	}
+}
+
+/*
+ * Commands queued behind a handler that is replaced or removed are still
+ * answered, however the change lands against the commands coming in
+ */
+func TestCommandHandlerReplaced(t *testing.T) {
+	const commands = 16
+	mock := NewMockRadio(testVersion, 1)
+	api := connectMock(t, mock)
+	started := make(chan int, commands)
+	release := make(chan int)
+	api.RegisterCommandHandler("slice", func(argv []string) (string, uint32) {
+		started <- 1
+		<-release
+		return "old", 0
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+	defer cancel()
+	var wg sync.WaitGroup
+	errs := make(chan error, 2*commands)
+	send := func(i int) {
+		defer wg.Done()
+		resp, status, err := mock.SendCommand(ctx, fmt.Sprintf("slice %d", i))
+		if err != nil {
+			errs <- fmt.Errorf("slice %d: %v", i, err)
+		} else if status == 0 && resp != "old" && resp != "new" {
+			errs <- fmt.Errorf("slice %d: %q", i, resp)
+		}
+	}
+	for i := 0; i < commands; i++ {
+		wg.Add(1)
+		go send(i)
+	}
+	<-started
+	/* Let the rest queue up behind the first before replacing it */
+	api.handlerLock.RLock()
+	queue := api.cmdHandlers["slice"].queue.queue
+	api.handlerLock.RUnlock()
+	deadline := time.Now().Add(testTimeout)
+	for len(queue) < commands-1 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	api.RegisterCommandHandler("slice", func([]string) (string, uint32) {
+		return "new", 0
+	})
+	close(release)
+	wg.Wait()
+
+	/* Unregister racing with commands arriving */
+	for i := 0; i < commands; i++ {
+		wg.Add(1)
+		go send(commands + i)
+	}
+	api.Unregister(api.RegisterCommandHandler("slice", func([]string) (string, uint32) {
+		return "new", 0
+	}))
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+}
+
+/* Messages thrown away by a slow subscriber are counted against its token */
+func TestMessageHandlerDropped(t *testing.T) {
+	mock := NewMockRadio(testVersion, 1)
+	api := connectMock(t, mock)
+	release := make(chan int)
+	defer close(release)
+	token := api.SubscribeMessages(func(*RadioMessage) {
+		<-release
+	})
+	barrier := make(chan string, 1)
+	api.RegisterStatusHandler("", func(handle uint32, status string) {
+		barrier <- status
+	})
+	for i := 0; i < DEFAULT_HANDLER_QUEUE_LEN+10; i++ {
+		mock.PushMessage(0x01000001, fmt.Sprintf("message %d", i))
+	}
+	mock.PushStatus(1, "radio slices=4")
+	recvString(t, barrier)
+	if dropped := api.HandlerDropped(token); dropped == 0 {
+		t.Error("no messages counted as dropped")
+	}
+}
+
+func TestRegisterAfterStop(t *testing.T) {
+	mock := NewMockRadio(testVersion, 1)
+	api := connectMock(t, mock)
+	mock.Drop()
+	select {
+	case <-api.done:
+	case <-time.After(testTimeout):
+		t.Fatal("interface loop did not stop after the connection dropped")
+	}
+	/* stopDispatch runs just after done closes; wait for it under the lock */
+	deadline := time.Now().Add(testTimeout)
+	for {
+		api.handlerLock.RLock()
+		stopped := api.dispatchDone
+		api.handlerLock.RUnlock()
+		if stopped || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if token := api.RegisterStatusHandler("", func(uint32, string) {}); token != 0 {
+		t.Errorf("RegisterStatusHandler after stop returned %d, want 0", token)
+	}
+	if token := api.RegisterCommandHandler("slice", func([]string) (string, uint32) { return "", 0 }); token != 0 {
+		t.Errorf("RegisterCommandHandler after stop returned %d, want 0", token)
+	}
+	if token := api.SubscribeMessages(func(*RadioMessage) {}); token != 0 {
+		t.Errorf("SubscribeMessages after stop returned %d, want 0", token)
+	}
+}
+
+func recvString(t *testing.T, ch chan string) string {
+	t.Helper()
+	select {
+	case s := <-ch:
+		return s
+	case <-time.After(testTimeout):
+		t.Fatal("timed out waiting for handler")
+	}
+	return ""
+}
+
+/* A command still waiting when the connection drops is answered with an error */
+func TestSendCommandContextClosed(t *testing.T) {
+	mock := NewMockRadio(testVersion, 1)
+	hold := make(chan int)
+	defer close(hold)
+	mock.RespondFunc("hold", func(string) (uint32, string) {
+		<-hold
+		return 0, ""
+	})
+	api := connectMock(t, mock)
+
+	errs := make(chan error, 1)
+	err := api.SendCommandContext(context.Background(), "hold", func(resp string, status uint32, err error) {
+		errs <- err
+	})
+	if err != nil {
+		t.Fatalf("SendCommandContext: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+	defer cancel()
+	if _, err := mock.WaitForCommand(ctx, "hold"); err != nil {
+		t.Fatal(err)
+	}
+	mock.Drop()
+	select {
+	case err := <-errs:
+		if err != ErrAPILoopStopped {
+			t.Errorf("callback got %v, want ErrAPILoopStopped", err)
+		}
+	case <-time.After(testTimeout):
+		t.Fatal("callback not called after the connection dropped")
+	}
+	if err := api.SendCommandContext(context.Background(), "hold", func(string, uint32, error) {}); err != ErrAPILoopStopped {
+		t.Errorf("SendCommandContext after close returned %v", err)
+	}
+}
+
+func TestSendCommandContextCancelled(t *testing.T) {
+	mock := NewMockRadio(testVersion, 1)
+	hold := make(chan int)
+	defer close(hold)
+	mock.RespondFunc("hold", func(string) (uint32, string) {
+		<-hold
+		return 0, ""
+	})
+	api := connectMock(t, mock)
+
+	errs := make(chan error, 1)
+	ctx, cancel := context.WithCancel(context.Background())
+	api.SendCommandContext(ctx, "hold", func(resp string, status uint32, err error) {
+		errs <- err
+	})
+	cancel()
+	select {
+	case err := <-errs:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("callback got %v, want context.Canceled", err)
+		}
+	case <-time.After(testTimeout):
+		t.Fatal("callback not called after cancelling")
+	}
+}
@@ -0,0 +1,167 @@
+/* SPDX-License-Identifier: GPL-3.0
+ *
+ * Copyright (C) 2018 Brady O'Brien. All Rights Reserved.
+ *
+ * SmartSDR response status codes and the Go errors they map onto
+ */
+
+package api
+
+import (
+	"errors"
+	"fmt"
+)
+
+/* Status word returned in an R line */
+type StatusCode uint32
+
+/* Severity, held in the top nibble of a status code */
+type StatusSeverity int
+
+const (
+	SEVERITY_SUCCESS StatusSeverity = iota
+	SEVERITY_INFO
+	SEVERITY_WARNING
+	SEVERITY_ERROR
+	SEVERITY_FATAL
+)
+
+const STATUS_SEVERITY_MASK uint32 = 0xF0000000
+
+const (
+	SL_SUCCESS                  StatusCode = 0x00000000
+	SL_NO_FOUNDATION_RCVR       StatusCode = 0x50000001
+	SL_LICENSE_NO_SLICE_AVAIL   StatusCode = 0x50000002
+	SL_ALL_SLICES_IN_USE        StatusCode = 0x50000003
+	SL_SLICE_PARAM_OUT_OF_RANGE StatusCode = 0x50000004
+	SL_MALLOC_FAIL_SIGNAL_CHAIN StatusCode = 0x50000005
+	SL_MALLOC_FAIL_DSP_PROCESS  StatusCode = 0x50000006
+	SL_NOT_IMPLEMENTED          StatusCode = 0x50000007
+	SL_INVALID_SLICE_RECEIVER   StatusCode = 0x50000008
+	SL_INVALID_DSP_PROCESS      StatusCode = 0x50000009
+	SL_INVALID_METER            StatusCode = 0x5000000A
+	SL_INVALID_FREQUENCY        StatusCode = 0x50000011
+	SL_BAD_COMMAND              StatusCode = 0x50000015
+	SL_UNKNOWN_COMMAND          StatusCode = 0x50000016
+	SL_MALFORMED_COMMAND        StatusCode = 0x50000017
+	SL_INCORRECT_NUM_PARAMS     StatusCode = 0x5000002C
+	SL_BAD_FIELD                StatusCode = 0x5000002D
+	SL_NOT_PERMITTED            StatusCode = 0x5000002E
+	SL_LICENSE_REQUIRED         StatusCode = 0x50000030
+)
+
+/* Broad classes of failure callers are likely to branch on */
+var ErrSliceNotFound = errors.New("slice not found")
+var ErrInvalidParameter = errors.New("invalid parameter")
+var ErrLicenseRequired = errors.New("license required")
+var ErrResourceExhausted = errors.New("radio resources exhausted")
+var ErrBadCommand = errors.New("bad command")
+
+type statusInfo struct {
+	name     string
+	text     string
+	category error
+}
+
+var statusCatalogue = map[StatusCode]statusInfo{
+	SL_SUCCESS:                  {"SL_SUCCESS", "success", nil},
+	SL_NO_FOUNDATION_RCVR:       {"SL_NO_FOUNDATION_RCVR", "no foundation receiver available", ErrResourceExhausted},
+	SL_LICENSE_NO_SLICE_AVAIL:   {"SL_LICENSE_NO_SLICE_AVAIL", "license allows no more slices", ErrLicenseRequired},
+	SL_ALL_SLICES_IN_USE:        {"SL_ALL_SLICES_IN_USE", "all slices in use", ErrResourceExhausted},
+	SL_SLICE_PARAM_OUT_OF_RANGE: {"SL_SLICE_PARAM_OUT_OF_RANGE", "slice parameter out of range", ErrInvalidParameter},
+	SL_MALLOC_FAIL_SIGNAL_CHAIN: {"SL_MALLOC_FAIL_SIGNAL_CHAIN", "could not allocate signal chain", ErrResourceExhausted},
+	SL_MALLOC_FAIL_DSP_PROCESS:  {"SL_MALLOC_FAIL_DSP_PROCESS", "could not allocate DSP process", ErrResourceExhausted},
+	SL_NOT_IMPLEMENTED:          {"SL_NOT_IMPLEMENTED", "not implemented", ErrBadCommand},
+	SL_INVALID_SLICE_RECEIVER:   {"SL_INVALID_SLICE_RECEIVER", "invalid slice receiver", ErrSliceNotFound},
+	SL_INVALID_DSP_PROCESS:      {"SL_INVALID_DSP_PROCESS", "invalid DSP process", ErrInvalidParameter},
+	SL_INVALID_METER:            {"SL_INVALID_METER", "invalid meter", ErrInvalidParameter},
+	SL_INVALID_FREQUENCY:        {"SL_INVALID_FREQUENCY", "invalid frequency", ErrInvalidParameter},
+	SL_BAD_COMMAND:              {"SL_BAD_COMMAND", "bad command", ErrBadCommand},
+	SL_UNKNOWN_COMMAND:          {"SL_UNKNOWN_COMMAND", "unknown command", ErrBadCommand},
+	SL_MALFORMED_COMMAND:        {"SL_MALFORMED_COMMAND", "malformed command", ErrBadCommand},
+	SL_INCORRECT_NUM_PARAMS:     {"SL_INCORRECT_NUM_PARAMS", "incorrect number of parameters", ErrInvalidParameter},
+	SL_BAD_FIELD:                {"SL_BAD_FIELD", "bad field", ErrInvalidParameter},
+	SL_NOT_PERMITTED:            {"SL_NOT_PERMITTED", "not permitted", ErrBadCommand},
+	SL_LICENSE_REQUIRED:         {"SL_LICENSE_REQUIRED", "feature requires a license", ErrLicenseRequired},
+}
+
+func (sev StatusSeverity) String() string {
+	switch sev {
+	case SEVERITY_SUCCESS:
+		return "success"
+	case SEVERITY_INFO:
+		return "info"
+	case SEVERITY_WARNING:
+		return "warning"
+	case SEVERITY_ERROR:
+		return "error"
+	case SEVERITY_FATAL:
+		return "fatal"
+	}
+	return "unknown"
+}
+
+func (code StatusCode) Severity() StatusSeverity {
+	switch (uint32(code) & STATUS_SEVERITY_MASK) >> 28 {
+	case 0x0:
+		return SEVERITY_SUCCESS
+	case 0x1:
+		return SEVERITY_INFO
+	case 0x3:
+		return SEVERITY_WARNING
+	case 0x5:
+		return SEVERITY_ERROR
+	}
+	/* Anything else is outside the documented range; treat it as the worst case */
+	return SEVERITY_FATAL
+}
+
+func (code StatusCode) Name() string {
+	if info, ok := statusCatalogue[code]; ok {
+		return info.name
+	}
+	return fmt.Sprintf("SL_%08X", uint32(code))
+}
+
+func (code StatusCode) Error() string {
+	if info, ok := statusCatalogue[code]; ok {
+		return fmt.Sprintf("%s (0x%08X): %s", info.name, uint32(code), info.text)
+	}
+	return fmt.Sprintf("unknown %s status 0x%08X", code.Severity(), uint32(code))
+}
+
+/* Lets errors.Is match a code against one of the Err* categories */
+func (code StatusCode) Is(target error) bool {
+	info, ok := statusCatalogue[code]
+	return ok && info.category != nil && info.category == target
+}
+
+/* Failed command, with the code and text the radio answered with */
+type CmdError struct {
+	Code     StatusCode
+	Command  string
+	Response string
+}
+
+func (e *CmdError) Error() string {
+	if e.Response != "" {
+		return fmt.Sprintf("command %q failed: %v: %s", e.Command, e.Code, e.Response)
+	}
+	return fmt.Sprintf("command %q failed: %v", e.Command, e.Code)
+}
+
+func (e *CmdError) Unwrap() error {
+	return e.Code
+}
+
+/* Wrap a non-zero status as a *CmdError; a zero status gives nil */
+func StatusError(status uint32, command, response string) error {
+	if StatusCode(status) == SL_SUCCESS {
+		return nil
+	}
+	return &CmdError{
+		Code:     StatusCode(status),
+		Command:  command,
+		Response: response,
+	}
+}
@@ -0,0 +1,102 @@
+/* SPDX-License-Identifier: GPL-3.0
+ *
+ * Copyright (C) 2018 Brady O'Brien. All Rights Reserved.
+ */
+
+package api
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+var statusCodeTests = []struct {
+	code     StatusCode
+	name     string
+	severity StatusSeverity
+	category error
+}{
+	{SL_SUCCESS, "SL_SUCCESS", SEVERITY_SUCCESS, nil},
+	{SL_ALL_SLICES_IN_USE, "SL_ALL_SLICES_IN_USE", SEVERITY_ERROR, ErrResourceExhausted},
+	{SL_LICENSE_NO_SLICE_AVAIL, "SL_LICENSE_NO_SLICE_AVAIL", SEVERITY_ERROR, ErrLicenseRequired},
+	{SL_LICENSE_REQUIRED, "SL_LICENSE_REQUIRED", SEVERITY_ERROR, ErrLicenseRequired},
+	{SL_SLICE_PARAM_OUT_OF_RANGE, "SL_SLICE_PARAM_OUT_OF_RANGE", SEVERITY_ERROR, ErrInvalidParameter},
+	{SL_INVALID_SLICE_RECEIVER, "SL_INVALID_SLICE_RECEIVER", SEVERITY_ERROR, ErrSliceNotFound},
+	{SL_BAD_COMMAND, "SL_BAD_COMMAND", SEVERITY_ERROR, ErrBadCommand},
+	{SL_UNKNOWN_COMMAND, "SL_UNKNOWN_COMMAND", SEVERITY_ERROR, ErrBadCommand},
+	/* Codes outside the catalogue keep their severity but match no category */
+	{0x10000123, "SL_10000123", SEVERITY_INFO, nil},
+	{0x30000456, "SL_30000456", SEVERITY_WARNING, nil},
+	{0x50000FFF, "SL_50000FFF", SEVERITY_ERROR, nil},
+	{0xE0000001, "SL_E0000001", SEVERITY_FATAL, nil},
+}
+
+var statusCategories = []error{ErrSliceNotFound, ErrInvalidParameter, ErrLicenseRequired, ErrResourceExhausted, ErrBadCommand}
+
+func TestStatusCodes(t *testing.T) {
+	for _, tc := range statusCodeTests {
+		t.Run(tc.name, func(t *testing.T) {
+			if name := tc.code.Name(); name != tc.name {
+				t.Errorf("name %q", name)
+			}
+			if sev := tc.code.Severity(); sev != tc.severity {
+				t.Errorf("severity %s, want %s", sev.String(), tc.severity.String())
+			}
+			if _, known := statusCatalogue[tc.code]; known && !strings.Contains(tc.code.Error(), tc.name) {
+				t.Errorf("error text %q doesn't name the code", tc.code.Error())
+			}
+			for _, category := range statusCategories {
+				if got := errors.Is(tc.code, category); got != (category == tc.category) {
+					t.Errorf("errors.Is(%v) = %v", category, got)
+				}
+			}
+		})
+	}
+}
+
+/* Every catalogue entry is named and described, and all but success are errors */
+func TestStatusCatalogueNames(t *testing.T) {
+	for code, info := range statusCatalogue {
+		if !strings.HasPrefix(info.name, "SL_") || info.text == "" {
+			t.Errorf("0x%08X: entry %+v", uint32(code), info)
+		}
+		if code != SL_SUCCESS && code.Severity() != SEVERITY_ERROR {
+			t.Errorf("%s has severity %s", info.name, code.Severity().String())
+		}
+	}
+}
+
+func TestStatusError(t *testing.T) {
+	if err := StatusError(0, "slice list", ""); err != nil {
+		t.Errorf("success gave %v", err)
+	}
+	err := StatusError(uint32(SL_ALL_SLICES_IN_USE), "slice create", "no free slice")
+	var cmdErr *CmdError
+	if !errors.As(err, &cmdErr) {
+		t.Fatalf("got %T, want *CmdError", err)
+	}
+	if cmdErr.Command != "slice create" || cmdErr.Response != "no free slice" {
+		t.Errorf("got %+v", cmdErr)
+	}
+	if errors.Unwrap(err) != SL_ALL_SLICES_IN_USE {
+		t.Errorf("unwraps to %v", errors.Unwrap(err))
+	}
+	if !errors.Is(err, SL_ALL_SLICES_IN_USE) || !errors.Is(err, ErrResourceExhausted) {
+		t.Error("errors.Is doesn't see through CmdError to the code and its category")
+	}
+	if errors.Is(err, ErrBadCommand) || errors.Is(err, SL_BAD_COMMAND) {
+		t.Error("matched a different code or category")
+	}
+	/* Still matches when wrapped further up */
+	wrapped := fmt.Errorf("setup: %w", err)
+	if !errors.Is(wrapped, ErrResourceExhausted) {
+		t.Error("lost the category through fmt.Errorf")
+	}
+	for _, want := range []string{"slice create", "SL_ALL_SLICES_IN_USE", "no free slice"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q lacks %q", err.Error(), want)
+		}
+	}
+}
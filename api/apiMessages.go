@@ -0,0 +1,122 @@
+/* SPDX-License-Identifier: GPL-3.0
+ *
+ * Copyright (C) 2018 Brady O'Brien. All Rights Reserved.
+ *
+ * Asynchronous radio messages (M lines)
+ */
+
+package api
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+type MessageSeverity int
+
+const (
+	MSG_INFO MessageSeverity = iota
+	MSG_WARNING
+	MSG_ERROR
+	MSG_FATAL
+)
+
+/* Severity lives in bits 24-25 of the message number */
+const MESSAGE_SEVERITY_MASK uint32 = 0x03000000
+const MESSAGE_SEVERITY_SHIFT = 24
+
+/* Operator-visible message sent by the radio */
+type RadioMessage struct {
+	Num      uint32
+	Severity MessageSeverity
+	Text     string
+}
+
+type MessageHandler func(*RadioMessage)
+
+type msgHandlerEntry struct {
+	token   HandlerToken
+	handler MessageHandler
+	queue   *dispatchQueue
+}
+
+func (sev MessageSeverity) String() string {
+	switch sev {
+	case MSG_INFO:
+		return "info"
+	case MSG_WARNING:
+		return "warning"
+	case MSG_ERROR:
+		return "error"
+	case MSG_FATAL:
+		return "fatal"
+	}
+	return "unknown"
+}
+
+func (msg *RadioMessage) String() string {
+	return msg.Severity.String() + ": " + msg.Text
+}
+
+/* Parse the body of an M line, "<hex message number>|<text>" */
+func parseMessage(msgStr string) (*RadioMessage, error) {
+	msgSegs := strings.SplitN(msgStr, "|", 2)
+	if len(msgSegs) < 2 {
+		return nil, errors.New("parseMessage: missing message text")
+	}
+	num, err := strconv.ParseUint(msgSegs[0], 16, 32)
+	if err != nil {
+		return nil, errors.New("parseMessage: bad message number")
+	}
+	msg := &RadioMessage{
+		Num:      uint32(num),
+		Severity: MessageSeverity((uint32(num) & MESSAGE_SEVERITY_MASK) >> MESSAGE_SEVERITY_SHIFT),
+		Text:     msgSegs[1],
+	}
+	return msg, nil
+}
+
+/*
+ * Deliver every radio message to handler, in order, on its own goroutine.
+ * Returns 0 once the interface loop has stopped.
+ */
+func (tcpi *SmartAPIInterface) SubscribeMessages(handler MessageHandler) HandlerToken {
+	tcpi.handlerLock.Lock()
+	defer tcpi.handlerLock.Unlock()
+	if tcpi.dispatchDone {
+		return 0
+	}
+	entry := &msgHandlerEntry{
+		token:   tcpi.newTokenLocked(),
+		handler: handler,
+		queue:   newDispatchQueue(DEFAULT_HANDLER_QUEUE_LEN, OVERFLOW_DROP_OLDEST),
+	}
+	handlers := make([]*msgHandlerEntry, len(tcpi.msgHandlers), len(tcpi.msgHandlers)+1)
+	copy(handlers, tcpi.msgHandlers)
+	tcpi.msgHandlers = append(handlers, entry)
+	return entry.token
+}
+
+func (tcpi *SmartAPIInterface) handleMessage(msgStr string) {
+	msg, err := parseMessage(msgStr)
+	if err != nil {
+		atomic.AddUint64(&tcpi.unknownLines, 1)
+		return
+	}
+	tcpi.handlerLock.RLock()
+	handlers := tcpi.msgHandlers
+	tcpi.handlerLock.RUnlock()
+	for _, entry := range handlers {
+		handler := entry.handler
+		entry.queue.push(func() {
+			handler(msg)
+		})
+	}
+}
+
+/* Number of lines received which could not be parsed */
+func (tcpi *SmartAPIInterface) UnknownLineCount() uint64 {
+	return atomic.LoadUint64(&tcpi.unknownLines)
+}
@@ -0,0 +1,213 @@
+/* SPDX-License-Identifier: GPL-3.0
+ *
+ * Copyright (C) 2018 Brady O'Brien. All Rights Reserved.
+ *
+ * Recording of TCP API sessions, and replay of recorded sessions against
+ * the mock radio or a client
+ */
+
+package api
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+/* Direction of a transcript line, as seen from the client */
+const (
+	/* Sent by the client to the radio */
+	TRANSCRIPT_SENT = '>'
+	/* Received by the client from the radio */
+	TRANSCRIPT_RECEIVED = '<'
+)
+
+const TRANSCRIPT_TIME_FORMAT = time.RFC3339Nano
+
+/* One line of a transcript: "<timestamp> <direction> <line>" */
+type TranscriptEntry struct {
+	Time time.Time
+	Dir  byte
+	Line string
+}
+
+type TranscriptRecorder struct {
+	lock   sync.Mutex
+	writer *bufio.Writer
+	closer io.Closer
+	err    error
+}
+
+func NewTranscriptRecorder(w io.Writer) *TranscriptRecorder {
+	rec := &TranscriptRecorder{writer: bufio.NewWriter(w)}
+	if c, ok := w.(io.Closer); ok {
+		rec.closer = c
+	}
+	return rec
+}
+
+/* Record to a new file at path, truncating any existing one */
+func CreateTranscriptFile(path string) (*TranscriptRecorder, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	return NewTranscriptRecorder(f), nil
+}
+
+/*
+ * Append a line to the transcript. Lines are flushed as they are written
+ * so the transcript survives a crash. The first write error is kept and
+ * returned by Close; later lines are dropped.
+ */
+func (rec *TranscriptRecorder) Record(dir byte, line string) {
+	now := time.Now()
+	line = strings.TrimRight(line, "\r\n")
+	rec.lock.Lock()
+	defer rec.lock.Unlock()
+	if rec.err != nil {
+		return
+	}
+	_, err := fmt.Fprintf(rec.writer, "%s %c %s\n", now.Format(TRANSCRIPT_TIME_FORMAT), dir, line)
+	if err == nil {
+		err = rec.writer.Flush()
+	}
+	rec.err = err
+}
+
+func (rec *TranscriptRecorder) Close() error {
+	rec.lock.Lock()
+	defer rec.lock.Unlock()
+	if rec.err == nil {
+		rec.err = rec.writer.Flush()
+	}
+	if rec.closer != nil {
+		if err := rec.closer.Close(); rec.err == nil {
+			rec.err = err
+		}
+	}
+	return rec.err
+}
+
+func ParseTranscriptLine(line string) (TranscriptEntry, error) {
+	segs := strings.SplitN(line, " ", 3)
+	if len(segs) < 3 || len(segs[1]) != 1 {
+		return TranscriptEntry{}, fmt.Errorf("ParseTranscriptLine: malformed line %q", line)
+	}
+	t, err := time.Parse(TRANSCRIPT_TIME_FORMAT, segs[0])
+	if err != nil {
+		return TranscriptEntry{}, fmt.Errorf("ParseTranscriptLine: bad timestamp %q", segs[0])
+	}
+	dir := segs[1][0]
+	if dir != TRANSCRIPT_SENT && dir != TRANSCRIPT_RECEIVED {
+		return TranscriptEntry{}, fmt.Errorf("ParseTranscriptLine: bad direction %q", segs[1])
+	}
+	return TranscriptEntry{Time: t, Dir: dir, Line: segs[2]}, nil
+}
+
+/* Read a whole transcript. Blank lines are skipped */
+func ReadTranscript(r io.Reader) ([]TranscriptEntry, error) {
+	entries := make([]TranscriptEntry, 0)
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if len(line) == 0 {
+			continue
+		}
+		entry, err := ParseTranscriptLine(line)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, scanner.Err()
+}
+
+/* Start recording every line sent and received. Pass nil to stop */
+func (tcpi *SmartAPIInterface) SetTranscript(rec *TranscriptRecorder) {
+	tcpi.infoLock.Lock()
+	defer tcpi.infoLock.Unlock()
+	tcpi.transcript = rec
+}
+
+func (tcpi *SmartAPIInterface) recordLine(dir byte, line string) {
+	tcpi.infoLock.Lock()
+	rec := tcpi.transcript
+	tcpi.infoLock.Unlock()
+	if rec != nil {
+		rec.Record(dir, line)
+	}
+}
+
+/* Plays one side of a transcript into a connection */
+type TranscriptReplayer struct {
+	Entries []TranscriptEntry
+	/*
+	 * Playback speed relative to the recording; 2 plays twice as fast.
+	 * Zero sends every line back to back.
+	 */
+	Speed float64
+}
+
+var ErrEmptyTranscript = errors.New("TranscriptReplayer: nothing to replay")
+
+/*
+ * Write the lines recorded in direction dir to conn, keeping their
+ * original spacing scaled by Speed. Anything read back from conn is
+ * discarded until Replay returns, after which conn may be read again. To
+ * drive a client, replay TRANSCRIPT_RECEIVED into one end of a net.Pipe
+ * with the client on the other; to drive the mock radio, replay
+ * TRANSCRIPT_SENT into the conn returned by MockRadio.Pipe.
+ */
+func (rp *TranscriptReplayer) Replay(ctx context.Context, conn net.Conn, dir byte) error {
+	if len(rp.Entries) == 0 {
+		return ErrEmptyTranscript
+	}
+	copyDone := make(chan int)
+	go func() {
+		io.Copy(io.Discard, conn)
+		close(copyDone)
+	}()
+	defer func() {
+		/* Knock the discarding read loose, then let reads through again */
+		if err := conn.SetReadDeadline(time.Unix(1, 0)); err != nil {
+			return
+		}
+		<-copyDone
+		conn.SetReadDeadline(time.Time{})
+	}()
+
+	start := rp.Entries[0].Time
+	began := time.Now()
+	for _, entry := range rp.Entries {
+		if entry.Dir != dir {
+			continue
+		}
+		if rp.Speed > 0 {
+			offset := time.Duration(float64(entry.Time.Sub(start)) / rp.Speed)
+			if wait := offset - time.Since(began); wait > 0 {
+				select {
+				case <-time.After(wait):
+				case <-ctx.Done():
+					return ctx.Err()
+				}
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		default:
+		}
+		if _, err := io.WriteString(conn, entry.Line+"\n"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,112 @@
+/* SPDX-License-Identifier: GPL-3.0
+ *
+ * Copyright (C) 2018 Brady O'Brien. All Rights Reserved.
+ */
+
+package api
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+/*
+ * A session recorded against one mock radio, read back, and replayed into
+ * another, sends the second radio the same commands
+ */
+func TestTranscriptRoundTrip(t *testing.T) {
+	mock := NewMockRadio(testVersion, 1)
+	mock.Respond("slice list", SL_SUCCESS, "0 1")
+	api := connectMock(t, mock)
+
+	var buf bytes.Buffer
+	rec := NewTranscriptRecorder(&buf)
+	api.SetTranscript(rec)
+	ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+	defer cancel()
+	commands := []string{"slice list", "sub slice all", "client program smartsdr-golang"}
+	for _, cmd := range commands {
+		if _, _, err := api.DoCommandContext(ctx, cmd); err != nil {
+			t.Fatalf("%s: %v", cmd, err)
+		}
+	}
+	api.SetTranscript(nil)
+	if err := rec.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	entries, err := ReadTranscript(&buf)
+	if err != nil {
+		t.Fatalf("ReadTranscript: %v", err)
+	}
+	var sent, received []string
+	for _, entry := range entries {
+		if entry.Dir == TRANSCRIPT_SENT {
+			sent = append(sent, entry.Line)
+		} else {
+			received = append(received, entry.Line)
+		}
+	}
+	if len(sent) != len(commands) || len(received) != len(commands) {
+		t.Fatalf("recorded %q sent and %q received", sent, received)
+	}
+	if !strings.HasSuffix(sent[0], "|slice list") || !strings.HasSuffix(received[0], "|0 1") {
+		t.Errorf("recorded %q answered with %q", sent[0], received[0])
+	}
+
+	replayed := NewMockRadio(testVersion, 2)
+	conn := replayed.Pipe()
+	defer conn.Close()
+	rp := &TranscriptReplayer{Entries: entries}
+	if err := rp.Replay(ctx, conn, TRANSCRIPT_SENT); err != nil {
+		t.Fatalf("Replay: %v", err)
+	}
+	if _, err := replayed.WaitForCommand(ctx, commands[len(commands)-1]); err != nil {
+		t.Fatal(err)
+	}
+	if got := replayed.Received(); !reflect.DeepEqual(got, commands) {
+		t.Errorf("replayed radio received %q, want %q", got, commands)
+	}
+
+	/* Replay no longer reads conn once it has returned */
+	conn.SetDeadline(time.Now().Add(testTimeout))
+	if _, err := conn.Write([]byte("C99|slice list\n")); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	reader := bufio.NewReader(conn)
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatalf("no reply after Replay returned: %v", err)
+		}
+		if strings.HasPrefix(line, "R99|") {
+			break
+		}
+	}
+}
+
+func TestReplayEmpty(t *testing.T) {
+	rp := &TranscriptReplayer{}
+	if err := rp.Replay(context.Background(), nil, TRANSCRIPT_SENT); err != ErrEmptyTranscript {
+		t.Errorf("got %v, want ErrEmptyTranscript", err)
+	}
+}
+
+func TestParseTranscriptLine(t *testing.T) {
+	line := "2018-03-04T05:06:07.5Z > C12|slice list"
+	entry, err := ParseTranscriptLine(line)
+	want := time.Date(2018, time.March, 4, 5, 6, 7, 500000000, time.UTC)
+	if err != nil || !entry.Time.Equal(want) || entry.Dir != TRANSCRIPT_SENT || entry.Line != "C12|slice list" {
+		t.Errorf("got %+v %v", entry, err)
+	}
+	for _, bad := range []string{"", "2018-03-04T05:06:07Z >", "yesterday > C1|x", "2018-03-04T05:06:07Z ? C1|x"} {
+		if _, err := ParseTranscriptLine(bad); err == nil {
+			t.Errorf("parsed %q", bad)
+		}
+	}
+}
@@ -0,0 +1,159 @@
+/* SPDX-License-Identifier: GPL-3.0
+ *
+ * Copyright (C) 2018 Brady O'Brien. All Rights Reserved.
+ *
+ * Registry of the radio's meters, built from "sub meter" status lines
+ */
+
+package api
+
+import (
+	"sort"
+	"strconv"
+	st "strings"
+	"sync"
+)
+
+/* A meter the radio has described to us */
+type MeterInfo struct {
+	ID uint16
+	/* Kind of object the meter belongs to, e.g. "SLC", "TX-", "RAD" or "WAVEFORM" */
+	Source string
+	/* Which one of them, e.g. the slice number */
+	SourceIndex string
+	Name        string
+	Desc        string
+	Unit        string
+	Low         float64
+	High        float64
+	FPS         int
+}
+
+/* Meters are sent as fixed point; the unit decides the scale */
+func MeterScale(unit string) float32 {
+	switch st.ToLower(unit) {
+	case "db", "dbm", "dbfs", "swr":
+		return 128
+	case "volts", "amps":
+		return 256
+	case "degc", "degf":
+		return 64
+	}
+	return 1
+}
+
+/* Convert a raw meter value from the meter stream into the meter's unit */
+func (meter *MeterInfo) Value(raw int16) float32 {
+	return float32(raw) / MeterScale(meter.Unit)
+}
+
+type MeterRegistry struct {
+	lock   sync.RWMutex
+	meters map[uint16]*MeterInfo
+}
+
+func NewMeterRegistry() *MeterRegistry {
+	return &MeterRegistry{meters: make(map[uint16]*MeterInfo)}
+}
+
+/* StatusHandler which feeds "meter" status lines into the registry */
+func (reg *MeterRegistry) HandleStatus(handle uint32, status string) {
+	reg.Update(status)
+}
+
+/*
+ * Apply one status line. Meter status comes as "meter 5 removed", or as
+ * '#' separated fields like "meter 5.src=SLC#5.num=0#5.nam=LEVEL#5.unit=dBm#",
+ * possibly covering several meters. Returns false if it wasn't meter status.
+ */
+func (reg *MeterRegistry) Update(status string) bool {
+	if !st.HasPrefix(status, "meter ") {
+		return false
+	}
+	body := st.TrimSpace(status[len("meter "):])
+
+	reg.lock.Lock()
+	defer reg.lock.Unlock()
+	if words := st.Fields(body); len(words) == 2 && words[1] == "removed" {
+		id, err := strconv.ParseUint(words[0], 10, 16)
+		if err != nil {
+			return false
+		}
+		delete(reg.meters, uint16(id))
+		return true
+	}
+
+	for _, field := range st.Split(body, "#") {
+		eq := st.IndexByte(field, '=')
+		dot := st.IndexByte(field, '.')
+		if eq < 0 || dot < 0 || dot > eq {
+			continue
+		}
+		id, err := strconv.ParseUint(st.TrimSpace(field[:dot]), 10, 16)
+		if err != nil {
+			continue
+		}
+		meter, ok := reg.meters[uint16(id)]
+		if !ok {
+			meter = &MeterInfo{ID: uint16(id)}
+			reg.meters[meter.ID] = meter
+		}
+		meter.set(field[dot+1:eq], field[eq+1:])
+	}
+	return true
+}
+
+func (meter *MeterInfo) set(key, value string) {
+	switch st.ToLower(key) {
+	case "src":
+		meter.Source = value
+	case "num":
+		meter.SourceIndex = value
+	case "nam":
+		meter.Name = value
+	case "desc":
+		meter.Desc = value
+	case "unit":
+		meter.Unit = value
+	case "low":
+		meter.Low = statusFloat(value)
+	case "hi":
+		meter.High = statusFloat(value)
+	case "fps":
+		meter.FPS = statusInt(value)
+	}
+}
+
+func (reg *MeterRegistry) Meter(id uint16) (MeterInfo, bool) {
+	reg.lock.RLock()
+	defer reg.lock.RUnlock()
+	meter, ok := reg.meters[id]
+	if !ok {
+		return MeterInfo{}, false
+	}
+	return *meter, true
+}
+
+/* Find a meter by source and name, e.g. ("SLC", "0", "LEVEL") */
+func (reg *MeterRegistry) Find(source, sourceIndex, name string) (MeterInfo, bool) {
+	reg.lock.RLock()
+	defer reg.lock.RUnlock()
+	for _, meter := range reg.meters {
+		if meter.Source == source && meter.SourceIndex == sourceIndex && meter.Name == name {
+			return *meter, true
+		}
+	}
+	return MeterInfo{}, false
+}
+
+/* All known meters, by ID */
+func (reg *MeterRegistry) Meters() []MeterInfo {
+	reg.lock.RLock()
+	defer reg.lock.RUnlock()
+	meters := make([]MeterInfo, 0, len(reg.meters))
+	for _, meter := range reg.meters {
+		meters = append(meters, *meter)
+	}
+	sort.Slice(meters, func(i, j int) bool { return meters[i].ID < meters[j].ID })
+	return meters
+}
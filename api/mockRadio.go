@@ -0,0 +1,365 @@
+/* SPDX-License-Identifier: GPL-3.0
+ *
+ * Copyright (C) 2018 Brady O'Brien. All Rights Reserved.
+ *
+ * In-process stand-in for a radio's TCP API, so SmartAPIInterface and the
+ * waveform setup can be exercised without hardware
+ */
+
+package api
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+/* Produces the status and response text for a command sent to the mock */
+type MockResponder func(cmd string) (uint32, string)
+
+type mockResponse struct {
+	prefix    string
+	responder MockResponder
+}
+
+/*
+ * Lines queued for one client. They are written from their own goroutine
+ * so the read loop never blocks on a client that is itself busy writing.
+ */
+type mockClient struct {
+	conn   net.Conn
+	lock   sync.Mutex
+	lines  []string
+	wake   chan int
+	closed chan int
+}
+
+type MockRadio struct {
+	Version FlexVersion
+	Handle  uint32
+
+	lock      sync.Mutex
+	client    *mockClient
+	listener  net.Listener
+	responses []mockResponse
+	received  []string
+	/* Wakes WaitForCommand when a command arrives */
+	cmdArrived chan int
+	/* Radio-originated commands waiting on a response, by sequence number */
+	cmdSeq  int
+	pending map[int]chan *CmdResponse
+}
+
+var ErrMockNotConnected = errors.New("MockRadio: no client connected")
+
+func NewMockRadio(version FlexVersion, handle uint32) *MockRadio {
+	return &MockRadio{
+		Version:    version,
+		Handle:     handle,
+		cmdArrived: make(chan int),
+		pending:    make(map[int]chan *CmdResponse),
+	}
+}
+
+/* Serve a single client over an in-memory pipe; returns the client end */
+func (mock *MockRadio) Pipe() net.Conn {
+	radioEnd, clientEnd := net.Pipe()
+	go mock.serve(radioEnd)
+	return clientEnd
+}
+
+/*
+ * Accept clients on a loopback listener, one after another, and return
+ * the address to dial
+ */
+func (mock *MockRadio) Listen(addr string) (net.Addr, error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	mock.lock.Lock()
+	mock.listener = listener
+	mock.lock.Unlock()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			mock.serve(conn)
+		}
+	}()
+	return listener.Addr(), nil
+}
+
+/*
+ * Answer commands starting with prefix with status and resp. Later
+ * registrations take priority over earlier ones; unmatched commands
+ * succeed with an empty response.
+ */
+func (mock *MockRadio) Respond(prefix string, status StatusCode, resp string) {
+	mock.RespondFunc(prefix, func(string) (uint32, string) {
+		return uint32(status), resp
+	})
+}
+
+func (mock *MockRadio) RespondFunc(prefix string, responder MockResponder) {
+	mock.lock.Lock()
+	defer mock.lock.Unlock()
+	mock.responses = append(mock.responses, mockResponse{prefix, responder})
+}
+
+func (mock *MockRadio) respond(cmd string) (uint32, string) {
+	mock.lock.Lock()
+	var responder MockResponder
+	for i := len(mock.responses) - 1; i >= 0; i-- {
+		if strings.HasPrefix(cmd, mock.responses[i].prefix) {
+			responder = mock.responses[i].responder
+			break
+		}
+	}
+	mock.lock.Unlock()
+	if responder == nil {
+		return 0, ""
+	}
+	return responder(cmd)
+}
+
+func newMockClient(conn net.Conn) *mockClient {
+	return &mockClient{
+		conn:   conn,
+		wake:   make(chan int, 1),
+		closed: make(chan int),
+	}
+}
+
+func (client *mockClient) queue(line string) error {
+	client.lock.Lock()
+	defer client.lock.Unlock()
+	select {
+	case <-client.closed:
+		return ErrMockNotConnected
+	default:
+	}
+	client.lines = append(client.lines, line)
+	select {
+	case client.wake <- 1:
+	default:
+	}
+	return nil
+}
+
+/* Write queued lines in order until the client goes away */
+func (client *mockClient) writeLoop() {
+	for {
+		client.lock.Lock()
+		lines := client.lines
+		client.lines = nil
+		client.lock.Unlock()
+		for _, line := range lines {
+			if _, err := io.WriteString(client.conn, line); err != nil {
+				client.conn.Close()
+				return
+			}
+		}
+		if len(lines) > 0 {
+			continue
+		}
+		select {
+		case <-client.wake:
+		case <-client.closed:
+			return
+		}
+	}
+}
+
+/* Queue a line for the current client */
+func (mock *MockRadio) writeLine(line string) error {
+	mock.lock.Lock()
+	client := mock.client
+	mock.lock.Unlock()
+	if client == nil {
+		return ErrMockNotConnected
+	}
+	return client.queue(line)
+}
+
+/* Run the protocol on conn until the client goes away */
+func (mock *MockRadio) serve(conn net.Conn) {
+	client := newMockClient(conn)
+	mock.lock.Lock()
+	mock.client = client
+	mock.lock.Unlock()
+	go client.writeLoop()
+	defer func() {
+		mock.lock.Lock()
+		if mock.client == client {
+			mock.client = nil
+		}
+		mock.lock.Unlock()
+		client.lock.Lock()
+		close(client.closed)
+		client.lock.Unlock()
+		conn.Close()
+	}()
+
+	if err := mock.writeLine(fmt.Sprintf("V%s\nH%08X\n", mock.Version.String(), mock.Handle)); err != nil {
+		return
+	}
+	reader := bufio.NewReader(conn)
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if len(line) == 0 {
+			continue
+		}
+		switch line[0] {
+		case 'C':
+			mock.handleClientCommand(line[1:])
+		case 'R':
+			mock.handleClientResponse(line[1:])
+		}
+	}
+}
+
+func (mock *MockRadio) handleClientCommand(cmdStr string) {
+	cmdSegs := strings.SplitN(cmdStr, "|", 2)
+	if len(cmdSegs) < 2 {
+		return
+	}
+	/* Commands may be flagged for debug with a leading D */
+	seq, err := strconv.Atoi(strings.TrimPrefix(cmdSegs[0], "D"))
+	if err != nil {
+		return
+	}
+	cmd := cmdSegs[1]
+	mock.lock.Lock()
+	mock.received = append(mock.received, cmd)
+	close(mock.cmdArrived)
+	mock.cmdArrived = make(chan int)
+	mock.lock.Unlock()
+
+	status, resp := mock.respond(cmd)
+	mock.writeLine(fmt.Sprintf("R%d|%08X|%s\n", seq, status, resp))
+}
+
+func (mock *MockRadio) handleClientResponse(respStr string) {
+	respSegs := strings.SplitN(respStr, "|", 3)
+	if len(respSegs) < 2 {
+		return
+	}
+	seq, err := strconv.Atoi(respSegs[0])
+	if err != nil {
+		return
+	}
+	status, _ := strconv.ParseUint(respSegs[1], 16, 32)
+	resp := &CmdResponse{Status: uint32(status)}
+	if len(respSegs) == 3 {
+		resp.RespStr = respSegs[2]
+	}
+	mock.lock.Lock()
+	respChan, ok := mock.pending[seq]
+	delete(mock.pending, seq)
+	mock.lock.Unlock()
+	if ok {
+		respChan <- resp
+	}
+}
+
+/* Queue a status line for the client */
+func (mock *MockRadio) PushStatus(handle uint32, status string) error {
+	return mock.writeLine(fmt.Sprintf("S%08X|%s\n", handle, status))
+}
+
+/* Queue a message line for the client */
+func (mock *MockRadio) PushMessage(num uint32, text string) error {
+	return mock.writeLine(fmt.Sprintf("M%08X|%s\n", num, text))
+}
+
+/* Queue a raw line for the client, for exercising malformed input */
+func (mock *MockRadio) PushLine(line string) error {
+	return mock.writeLine(line + "\n")
+}
+
+/* Send a radio-originated command to the client and wait for its response */
+func (mock *MockRadio) SendCommand(ctx context.Context, cmd string) (string, uint32, error) {
+	respChan := make(chan *CmdResponse, 1)
+	mock.lock.Lock()
+	mock.cmdSeq++
+	seq := mock.cmdSeq
+	mock.pending[seq] = respChan
+	mock.lock.Unlock()
+
+	if err := mock.writeLine(fmt.Sprintf("C%d|%s\n", seq, cmd)); err != nil {
+		mock.lock.Lock()
+		delete(mock.pending, seq)
+		mock.lock.Unlock()
+		return "", 0, err
+	}
+	select {
+	case resp := <-respChan:
+		return resp.RespStr, resp.Status, nil
+	case <-ctx.Done():
+		mock.lock.Lock()
+		delete(mock.pending, seq)
+		mock.lock.Unlock()
+		return "", 0, ctx.Err()
+	}
+}
+
+/* Every command the client has sent, in order, without sequence numbers */
+func (mock *MockRadio) Received() []string {
+	mock.lock.Lock()
+	defer mock.lock.Unlock()
+	return append([]string(nil), mock.received...)
+}
+
+/* Wait until the client has sent a command starting with prefix */
+func (mock *MockRadio) WaitForCommand(ctx context.Context, prefix string) (string, error) {
+	for {
+		mock.lock.Lock()
+		arrived := mock.cmdArrived
+		for _, cmd := range mock.received {
+			if strings.HasPrefix(cmd, prefix) {
+				mock.lock.Unlock()
+				return cmd, nil
+			}
+		}
+		mock.lock.Unlock()
+		select {
+		case <-arrived:
+		case <-ctx.Done():
+			return "", fmt.Errorf("WaitForCommand %q: %w", prefix, ctx.Err())
+		}
+	}
+}
+
+/* Drop the current client connection, as a radio reboot would */
+func (mock *MockRadio) Drop() {
+	mock.lock.Lock()
+	client := mock.client
+	mock.lock.Unlock()
+	if client != nil {
+		client.conn.Close()
+	}
+}
+
+func (mock *MockRadio) Close() {
+	mock.lock.Lock()
+	listener := mock.listener
+	mock.listener = nil
+	mock.lock.Unlock()
+	if listener != nil {
+		listener.Close()
+	}
+	mock.Drop()
+}
@@ -0,0 +1,400 @@
+/* SPDX-License-Identifier: GPL-3.0
+ *
+ * Copyright (C) 2018 Brady O'Brien. All Rights Reserved.
+ *
+ * Typed, incrementally updated view of the radio built from status lines
+ */
+
+package api
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+/* Old and new value of a status field; Old is empty for new fields */
+type FieldChange struct {
+	Old string
+	New string
+}
+
+/* Set of fields which changed on one status object */
+type StatusChange struct {
+	/* "slice", "radio", "interlock", "transmit" or "waveform" */
+	Object string
+	/* Slice index, or -1 for singleton objects */
+	Index   int
+	Changed map[string]FieldChange
+	/* The object went away (e.g. slice in_use=0) */
+	Removed bool
+	/* Sub-object the change applies to (e.g. "filter_sharpness VOICE"), or empty */
+	Sub string
+}
+
+type StatusChangeHandler func(*StatusChange)
+
+/*
+ * Each state's Sub holds the fields of its sub-objects, keyed by the words
+ * naming them, so "radio filter_sharpness VOICE level=2" lands in
+ * Radio().Sub["filter_sharpness VOICE"] rather than the radio's own Fields
+ */
+
+type SliceState struct {
+	Index       int
+	InUse       bool
+	RFFrequency float64
+	Mode        string
+	TxEnabled   bool
+	Fields      map[string]string
+	Sub         map[string]map[string]string
+}
+
+type RadioState struct {
+	Nickname    string
+	Callsign    string
+	Slices      int
+	Panadapters int
+	Fields      map[string]string
+	Sub         map[string]map[string]string
+}
+
+type InterlockState struct {
+	State     string
+	Reason    string
+	Source    string
+	TxAllowed bool
+	Fields    map[string]string
+	Sub       map[string]map[string]string
+}
+
+type TransmitState struct {
+	Frequency float64
+	RFPower   int
+	TunePower int
+	Fields    map[string]string
+	Sub       map[string]map[string]string
+}
+
+type WaveformState struct {
+	Fields map[string]string
+	Sub    map[string]map[string]string
+}
+
+type StatusStore struct {
+	lock      sync.RWMutex
+	slices    map[int]*SliceState
+	radio     RadioState
+	interlock InterlockState
+	transmit  TransmitState
+	waveform  WaveformState
+	nextToken HandlerToken
+	listeners map[HandlerToken]StatusChangeHandler
+}
+
+func NewStatusStore() *StatusStore {
+	return &StatusStore{
+		slices:    make(map[int]*SliceState),
+		radio:     RadioState{Fields: make(map[string]string), Sub: make(map[string]map[string]string)},
+		interlock: InterlockState{Fields: make(map[string]string), Sub: make(map[string]map[string]string)},
+		transmit:  TransmitState{Fields: make(map[string]string), Sub: make(map[string]map[string]string)},
+		waveform:  WaveformState{Fields: make(map[string]string), Sub: make(map[string]map[string]string)},
+		listeners: make(map[HandlerToken]StatusChangeHandler),
+	}
+}
+
+func statusBool(v string) bool {
+	b, err := strconv.ParseBool(v)
+	return err == nil && b
+}
+
+func statusFloat(v string) float64 {
+	f, _ := strconv.ParseFloat(v, 64)
+	return f
+}
+
+func statusInt(v string) int {
+	i, _ := strconv.Atoi(v)
+	return i
+}
+
+/* Merge tokens into fields, recording what changed */
+func mergeFields(fields map[string]string, tokens map[string]string, change *StatusChange) {
+	for k, v := range tokens {
+		old, ok := fields[k]
+		if ok && old == v {
+			continue
+		}
+		fields[k] = v
+		change.Changed[k] = FieldChange{Old: old, New: v}
+	}
+}
+
+func copyFields(fields map[string]string) map[string]string {
+	c := make(map[string]string, len(fields))
+	for k, v := range fields {
+		c[k] = v
+	}
+	return c
+}
+
+func copySub(sub map[string]map[string]string) map[string]map[string]string {
+	c := make(map[string]map[string]string, len(sub))
+	for k, fields := range sub {
+		c[k] = copyFields(fields)
+	}
+	return c
+}
+
+/*
+ * Merge tokens into the sub-object named by words, creating it if need
+ * be, or delete it if removed
+ */
+func mergeSub(sub map[string]map[string]string, words []string, tokens map[string]string, removed bool, change *StatusChange) {
+	change.Sub = strings.Join(words, " ")
+	fields, ok := sub[change.Sub]
+	if removed {
+		if ok {
+			delete(sub, change.Sub)
+			change.Removed = true
+		}
+		return
+	}
+	if !ok {
+		fields = make(map[string]string)
+		sub[change.Sub] = fields
+	}
+	mergeFields(fields, tokens, change)
+}
+
+func (s *SliceState) refresh() {
+	s.InUse = statusBool(s.Fields["in_use"])
+	s.RFFrequency = statusFloat(s.Fields["RF_frequency"])
+	s.Mode = s.Fields["mode"]
+	s.TxEnabled = statusBool(s.Fields["tx"])
+}
+
+func (r *RadioState) refresh() {
+	r.Nickname = r.Fields["nickname"]
+	r.Callsign = r.Fields["callsign"]
+	r.Slices = statusInt(r.Fields["slices"])
+	r.Panadapters = statusInt(r.Fields["panadapters"])
+}
+
+func (il *InterlockState) refresh() {
+	il.State = il.Fields["state"]
+	il.Reason = il.Fields["reason"]
+	il.Source = il.Fields["source"]
+	il.TxAllowed = statusBool(il.Fields["tx_allowed"])
+}
+
+func (tx *TransmitState) refresh() {
+	tx.Frequency = statusFloat(tx.Fields["freq"])
+	tx.RFPower = statusInt(tx.Fields["rfpower"])
+	tx.TunePower = statusInt(tx.Fields["tunepower"])
+}
+
+/*
+ * Split a status line into its leading object words and key=value tokens,
+ * e.g. "slice 0 mode=USB" gives ["slice", "0"] and {mode: USB}
+ */
+func splitStatus(status string) ([]string, map[string]string) {
+	toks := Tokenize(status)
+	nobj := 0
+	for nobj < len(toks.Tokens) && !toks.Tokens[nobj].HasValue {
+		nobj++
+	}
+	return toks.Args[:nobj], toks.Values
+}
+
+/* StatusHandler which feeds status lines into the store */
+func (store *StatusStore) HandleStatus(handle uint32, status string) {
+	if change := store.Update(status); change != nil {
+		store.notify(change)
+	}
+}
+
+/*
+ * Apply one status line. Words after the object (and slice index) name a
+ * sub-object; a trailing "removed" deletes the slice or sub-object.
+ * Returns what changed, or nil if nothing did
+ */
+func (store *StatusStore) Update(status string) *StatusChange {
+	objWords, tokens := splitStatus(status)
+	if len(objWords) == 0 {
+		return nil
+	}
+	removed := len(objWords) > 1 && objWords[len(objWords)-1] == "removed"
+	if removed {
+		objWords = objWords[:len(objWords)-1]
+	}
+	change := &StatusChange{
+		Object:  objWords[0],
+		Index:   -1,
+		Changed: make(map[string]FieldChange),
+	}
+
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	switch objWords[0] {
+	case "slice":
+		if len(objWords) < 2 {
+			return nil
+		}
+		idx, err := strconv.Atoi(objWords[1])
+		if err != nil {
+			return nil
+		}
+		change.Index = idx
+		slice, ok := store.slices[idx]
+		if len(objWords) > 2 {
+			if ok {
+				mergeSub(slice.Sub, objWords[2:], tokens, removed, change)
+			}
+			break
+		}
+		if removed {
+			if ok {
+				delete(store.slices, idx)
+				change.Removed = true
+			}
+			break
+		}
+		if !ok {
+			slice = &SliceState{Index: idx, Fields: make(map[string]string), Sub: make(map[string]map[string]string)}
+			store.slices[idx] = slice
+		}
+		mergeFields(slice.Fields, tokens, change)
+		slice.refresh()
+		if inUse, ok := tokens["in_use"]; ok && !statusBool(inUse) {
+			delete(store.slices, idx)
+			change.Removed = true
+		}
+	case "radio":
+		if len(objWords) > 1 {
+			mergeSub(store.radio.Sub, objWords[1:], tokens, removed, change)
+			break
+		}
+		mergeFields(store.radio.Fields, tokens, change)
+		store.radio.refresh()
+	case "interlock":
+		if len(objWords) > 1 {
+			mergeSub(store.interlock.Sub, objWords[1:], tokens, removed, change)
+			break
+		}
+		mergeFields(store.interlock.Fields, tokens, change)
+		store.interlock.refresh()
+	case "transmit":
+		if len(objWords) > 1 {
+			mergeSub(store.transmit.Sub, objWords[1:], tokens, removed, change)
+			break
+		}
+		mergeFields(store.transmit.Fields, tokens, change)
+		store.transmit.refresh()
+	case "waveform":
+		if len(objWords) > 1 {
+			mergeSub(store.waveform.Sub, objWords[1:], tokens, removed, change)
+			break
+		}
+		mergeFields(store.waveform.Fields, tokens, change)
+	default:
+		return nil
+	}
+	if len(change.Changed) == 0 && !change.Removed {
+		return nil
+	}
+	return change
+}
+
+func (store *StatusStore) notify(change *StatusChange) {
+	store.lock.RLock()
+	listeners := make([]StatusChangeHandler, 0, len(store.listeners))
+	for _, l := range store.listeners {
+		listeners = append(listeners, l)
+	}
+	store.lock.RUnlock()
+	for _, l := range listeners {
+		l(change)
+	}
+}
+
+/* Call handler with every change to the store */
+func (store *StatusStore) Subscribe(handler StatusChangeHandler) HandlerToken {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	store.nextToken++
+	store.listeners[store.nextToken] = handler
+	return store.nextToken
+}
+
+func (store *StatusStore) Unsubscribe(token HandlerToken) {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	delete(store.listeners, token)
+}
+
+/* Copy of slice idx, if the radio has reported it */
+func (store *StatusStore) Slice(idx int) (SliceState, bool) {
+	store.lock.RLock()
+	defer store.lock.RUnlock()
+	slice, ok := store.slices[idx]
+	if !ok {
+		return SliceState{}, false
+	}
+	c := *slice
+	c.Fields = copyFields(slice.Fields)
+	c.Sub = copySub(slice.Sub)
+	return c, true
+}
+
+/* Copies of all known slices, ordered by index */
+func (store *StatusStore) Slices() []SliceState {
+	store.lock.RLock()
+	idxs := make([]int, 0, len(store.slices))
+	for idx := range store.slices {
+		idxs = append(idxs, idx)
+	}
+	store.lock.RUnlock()
+	sort.Ints(idxs)
+	slices := make([]SliceState, 0, len(idxs))
+	for _, idx := range idxs {
+		if slice, ok := store.Slice(idx); ok {
+			slices = append(slices, slice)
+		}
+	}
+	return slices
+}
+
+func (store *StatusStore) Radio() RadioState {
+	store.lock.RLock()
+	defer store.lock.RUnlock()
+	c := store.radio
+	c.Fields = copyFields(store.radio.Fields)
+	c.Sub = copySub(store.radio.Sub)
+	return c
+}
+
+func (store *StatusStore) Interlock() InterlockState {
+	store.lock.RLock()
+	defer store.lock.RUnlock()
+	c := store.interlock
+	c.Fields = copyFields(store.interlock.Fields)
+	c.Sub = copySub(store.interlock.Sub)
+	return c
+}
+
+func (store *StatusStore) Transmit() TransmitState {
+	store.lock.RLock()
+	defer store.lock.RUnlock()
+	c := store.transmit
+	c.Fields = copyFields(store.transmit.Fields)
+	c.Sub = copySub(store.transmit.Sub)
+	return c
+}
+
+func (store *StatusStore) Waveform() WaveformState {
+	store.lock.RLock()
+	defer store.lock.RUnlock()
+	return WaveformState{Fields: copyFields(store.waveform.Fields), Sub: copySub(store.waveform.Sub)}
+}
@@ -0,0 +1,140 @@
+/* SPDX-License-Identifier: GPL-3.0
+ *
+ * Copyright (C) 2018 Brady O'Brien. All Rights Reserved.
+ */
+
+package api
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestStatusStoreSliceLifecycle(t *testing.T) {
+	store := NewStatusStore()
+
+	change := store.Update("slice 1 in_use=1 RF_frequency=14.236 mode=USB tx=1")
+	if change == nil || change.Object != "slice" || change.Index != 1 || change.Removed {
+		t.Fatalf("create gave %+v", change)
+	}
+	if got := change.Changed["mode"]; got != (FieldChange{Old: "", New: "USB"}) {
+		t.Errorf("mode change %+v", got)
+	}
+	slice, ok := store.Slice(1)
+	if !ok || !slice.InUse || slice.RFFrequency != 14.236 || slice.Mode != "USB" || !slice.TxEnabled {
+		t.Fatalf("created slice %+v %v", slice, ok)
+	}
+
+	change = store.Update("slice 1 mode=FDV RF_frequency=14.236")
+	if change == nil || len(change.Changed) != 1 || change.Changed["mode"] != (FieldChange{Old: "USB", New: "FDV"}) {
+		t.Errorf("field change gave %+v", change)
+	}
+	if slice, _ := store.Slice(1); slice.Mode != "FDV" {
+		t.Errorf("mode %q after change", slice.Mode)
+	}
+	if change := store.Update("slice 1 mode=FDV"); change != nil {
+		t.Errorf("repeated status gave %+v", change)
+	}
+
+	change = store.Update("slice 1 removed")
+	if change == nil || !change.Removed || change.Index != 1 {
+		t.Errorf("removal gave %+v", change)
+	}
+	if _, ok := store.Slice(1); ok {
+		t.Error("slice still present after removed")
+	}
+	if change := store.Update("slice 1 removed"); change != nil {
+		t.Errorf("removing an unknown slice gave %+v", change)
+	}
+
+	store.Update("slice 2 in_use=1")
+	if change := store.Update("slice 2 in_use=0"); change == nil || !change.Removed {
+		t.Errorf("in_use=0 gave %+v", change)
+	}
+	if slices := store.Slices(); len(slices) != 0 {
+		t.Errorf("slices left %+v", slices)
+	}
+}
+
+/* Sub-object status is kept apart from its parent's own fields */
+func TestStatusStoreSubObjects(t *testing.T) {
+	store := NewStatusStore()
+	store.Update("radio slices=4 panadapters=4 nickname=Shack")
+
+	change := store.Update("radio filter_sharpness VOICE level=2 auto_level=1")
+	if change == nil || change.Object != "radio" || change.Sub != "filter_sharpness VOICE" {
+		t.Fatalf("sub-object create gave %+v", change)
+	}
+	store.Update("radio filter_sharpness CW level=3")
+	change = store.Update("radio filter_sharpness VOICE level=1")
+	if change == nil || change.Changed["level"] != (FieldChange{Old: "2", New: "1"}) {
+		t.Errorf("sub-object change gave %+v", change)
+	}
+
+	radio := store.Radio()
+	want := map[string]string{"slices": "4", "panadapters": "4", "nickname": "Shack"}
+	if !reflect.DeepEqual(radio.Fields, want) {
+		t.Errorf("radio fields %v, want %v", radio.Fields, want)
+	}
+	if radio.Slices != 4 || radio.Nickname != "Shack" {
+		t.Errorf("radio %+v", radio)
+	}
+	wantSub := map[string]map[string]string{
+		"filter_sharpness VOICE": {"level": "1", "auto_level": "1"},
+		"filter_sharpness CW":    {"level": "3"},
+	}
+	if !reflect.DeepEqual(radio.Sub, wantSub) {
+		t.Errorf("radio sub-objects %v, want %v", radio.Sub, wantSub)
+	}
+
+	/* Copies don't alias the store */
+	radio.Sub["filter_sharpness CW"]["level"] = "9"
+	if level := store.Radio().Sub["filter_sharpness CW"]["level"]; level != "3" {
+		t.Errorf("store changed through a copy: level %q", level)
+	}
+
+	change = store.Update("radio filter_sharpness CW removed")
+	if change == nil || !change.Removed || change.Sub != "filter_sharpness CW" {
+		t.Errorf("sub-object removal gave %+v", change)
+	}
+	if _, ok := store.Radio().Sub["filter_sharpness CW"]; ok {
+		t.Error("sub-object still present after removed")
+	}
+	if fields := store.Radio().Fields; len(fields) != 3 {
+		t.Errorf("radio fields %v after sub-object removal", fields)
+	}
+}
+
+func TestStatusStoreNotify(t *testing.T) {
+	store := NewStatusStore()
+	var changes []*StatusChange
+	token := store.Subscribe(func(change *StatusChange) {
+		changes = append(changes, change)
+	})
+
+	store.HandleStatus(1, "interlock state=READY tx_allowed=1")
+	store.HandleStatus(1, "interlock state=READY")
+	store.HandleStatus(1, "meter 1.nam=FWDPWR")
+	store.HandleStatus(1, "transmit freq=14.236 rfpower=50")
+	if len(changes) != 2 {
+		t.Fatalf("got %d notifications, want 2", len(changes))
+	}
+	if changes[0].Object != "interlock" || changes[0].Changed["tx_allowed"].New != "1" {
+		t.Errorf("first notification %+v", changes[0])
+	}
+	if changes[1].Object != "transmit" || len(changes[1].Changed) != 2 {
+		t.Errorf("second notification %+v", changes[1])
+	}
+	if il := store.Interlock(); il.State != "READY" || !il.TxAllowed {
+		t.Errorf("interlock %+v", il)
+	}
+	if tx := store.Transmit(); tx.Frequency != 14.236 || tx.RFPower != 50 {
+		t.Errorf("transmit %+v", tx)
+	}
+
+	store.Unsubscribe(token)
+	store.HandleStatus(1, "transmit rfpower=10")
+	if len(changes) != 2 {
+		t.Errorf("notified after Unsubscribe")
+	}
+}
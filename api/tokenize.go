@@ -0,0 +1,108 @@
+/* SPDX-License-Identifier: GPL-3.0
+ *
+ * Copyright (C) 2018 Brady OBrien. All Rights Reserved.
+ */
+
+package api
+
+import "strings"
+
+/* The radio sends spaces inside values as this character */
+const TOKEN_SPACE_ESCAPE = '\x7f'
+
+/* One word of a SmartSDR token string; bare words have no value */
+type Token struct {
+	Key      string
+	Value    string
+	HasValue bool
+}
+
+/* Parsed token string */
+type TokenList struct {
+	/* Every token, in the order it appeared */
+	Tokens []Token
+	/* Bare words, in order */
+	Args []string
+	/* key=value pairs; later duplicates win */
+	Values map[string]string
+}
+
+func unescapeToken(s string) string {
+	return strings.Replace(s, string(TOKEN_SPACE_ESCAPE), " ", -1)
+}
+
+/*
+ * Escape a value so it survives as a single token. Values holding a
+ * double quote are also quoted, with quotes and backslashes escaped, so
+ * Tokenize gives them back unchanged.
+ */
+func EscapeTokenValue(s string) string {
+	s = strings.Replace(s, " ", string(TOKEN_SPACE_ESCAPE), -1)
+	if !strings.ContainsRune(s, '"') {
+		return s
+	}
+	s = strings.Replace(s, `\`, `\\`, -1)
+	return `"` + strings.Replace(s, `"`, `\"`, -1) + `"`
+}
+
+/*
+ * Split a token string into words. Runs of whitespace separate words and
+ * double quotes group a word containing whitespace; a backslash inside
+ * quotes escapes the next character.
+ */
+func splitTokenWords(tokenString string) []string {
+	words := make([]string, 0, 16)
+	var word strings.Builder
+	inWord, inQuote, escaped := false, false, false
+	for _, c := range tokenString {
+		switch {
+		case escaped:
+			word.WriteRune(c)
+			escaped = false
+		case inQuote && c == '\\':
+			escaped = true
+		case c == '"':
+			inQuote = !inQuote
+			inWord = true
+		case !inQuote && (c == ' ' || c == '\t' || c == '\r' || c == '\n'):
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		default:
+			word.WriteRune(c)
+			inWord = true
+		}
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+	return words
+}
+
+/*
+ * Tokenize a SmartSDR key=value string. Values may themselves contain
+ * '=' and may be empty; words without '=' are returned as positional args.
+ */
+func Tokenize(tokenString string) *TokenList {
+	words := splitTokenWords(tokenString)
+	toks := &TokenList{
+		Tokens: make([]Token, 0, len(words)),
+		Args:   make([]string, 0),
+		Values: make(map[string]string),
+	}
+	for _, word := range words {
+		parts := strings.SplitN(word, "=", 2)
+		if len(parts) == 2 {
+			tok := Token{unescapeToken(parts[0]), unescapeToken(parts[1]), true}
+			toks.Tokens = append(toks.Tokens, tok)
+			toks.Values[tok.Key] = tok.Value
+		} else {
+			tok := Token{Key: unescapeToken(word)}
+			toks.Tokens = append(toks.Tokens, tok)
+			toks.Args = append(toks.Args, tok.Key)
+		}
+	}
+	return toks
+}
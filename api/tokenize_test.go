@@ -0,0 +1,89 @@
+/* SPDX-License-Identifier: GPL-3.0
+ *
+ * Copyright (C) 2018 Brady OBrien. All Rights Reserved.
+ */
+
+package api
+
+import (
+	"reflect"
+	"testing"
+)
+
+var tokenizeTests = []struct {
+	name   string
+	input  string
+	words  []string
+	tokens []Token
+}{
+	{"empty", "  \t ", []string{}, []Token{}},
+	{"bare words", "slice 0 removed",
+		[]string{"slice", "0", "removed"},
+		[]Token{{Key: "slice"}, {Key: "0"}, {Key: "removed"}}},
+	{"key value pairs", "slice 1 mode=FDV  RF_frequency=14.236\t tx=1",
+		[]string{"slice", "1", "mode=FDV", "RF_frequency=14.236", "tx=1"},
+		[]Token{{Key: "slice"}, {Key: "1"}, {"mode", "FDV", true}, {"RF_frequency", "14.236", true}, {"tx", "1", true}}},
+	{"empty value", "radio callsign=", []string{"radio", "callsign="},
+		[]Token{{Key: "radio"}, {"callsign", "", true}}},
+	{"equals inside value", "waveform cmd=mode=700D", []string{"waveform", "cmd=mode=700D"},
+		[]Token{{Key: "waveform"}, {"cmd", "mode=700D", true}}},
+	{"quoted value with spaces", `radio nickname="Field Day rig" slices=4`,
+		[]string{"radio", "nickname=Field Day rig", "slices=4"},
+		[]Token{{Key: "radio"}, {"nickname", "Field Day rig", true}, {"slices", "4", true}}},
+	{"quoted bare word", `"two words" tail`, []string{"two words", "tail"},
+		[]Token{{Key: "two words"}, {Key: "tail"}}},
+	{"empty quotes", `text="" next`, []string{"text=", "next"},
+		[]Token{{"text", "", true}, {Key: "next"}}},
+	{"escaped quote", `text="say \"hi\" now"`, []string{`text=say "hi" now`},
+		[]Token{{"text", `say "hi" now`, true}}},
+	{"escaped backslash", `path="a\\b"`, []string{`path=a\b`},
+		[]Token{{"path", `a\b`, true}}},
+	/* Backslashes only escape inside quotes */
+	{"backslash outside quotes", `path=a\b`, []string{`path=a\b`},
+		[]Token{{"path", `a\b`, true}}},
+	{"escaped spaces", "radio nickname=Field\x7fDay", []string{"radio", "nickname=Field\x7fDay"},
+		[]Token{{Key: "radio"}, {"nickname", "Field Day", true}}},
+}
+
+func TestTokenize(t *testing.T) {
+	for _, tc := range tokenizeTests {
+		t.Run(tc.name, func(t *testing.T) {
+			if words := splitTokenWords(tc.input); !reflect.DeepEqual(words, tc.words) {
+				t.Errorf("words %q, want %q", words, tc.words)
+			}
+			toks := Tokenize(tc.input)
+			if !reflect.DeepEqual(toks.Tokens, tc.tokens) {
+				t.Errorf("tokens %+v, want %+v", toks.Tokens, tc.tokens)
+			}
+			args := []string{}
+			values := map[string]string{}
+			for _, tok := range tc.tokens {
+				if tok.HasValue {
+					values[tok.Key] = tok.Value
+				} else {
+					args = append(args, tok.Key)
+				}
+			}
+			if !reflect.DeepEqual(toks.Args, args) || !reflect.DeepEqual(toks.Values, values) {
+				t.Errorf("args %q values %v, want %q %v", toks.Args, toks.Values, args, values)
+			}
+		})
+	}
+}
+
+func TestTokenizeDuplicateKeys(t *testing.T) {
+	toks := Tokenize("slice 0 mode=USB mode=FDV")
+	if toks.Values["mode"] != "FDV" || len(toks.Tokens) != 4 {
+		t.Errorf("got %+v", toks)
+	}
+}
+
+/* Escaped values come back unchanged as a single token */
+func TestEscapeTokenValueRoundTrip(t *testing.T) {
+	for _, value := range []string{"", "plain", "Field Day rig", " leading and trailing ", "a=b c", `quote " inside`, `"quoted"`, `back\slash`, `back\slash "and" quotes\`} {
+		toks := Tokenize("key=" + EscapeTokenValue(value) + " next")
+		if len(toks.Tokens) != 2 || toks.Values["key"] != value || toks.Args[0] != "next" {
+			t.Errorf("%q came back as %+v", value, toks.Tokens)
+		}
+	}
+}
@@ -0,0 +1,122 @@
+/* SPDX-License-Identifier: GPL-3.0
+ *
+ * Copyright (C) 2018 Brady O'Brien. All Rights Reserved.
+ */
+
+package api
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	st "strings"
+	"time"
+)
+
+/* Contents of a waveform .cfg file */
+type WaveformConfig struct {
+	Name       string
+	MinVersion FlexVersion
+	/* Every "Key: value" line of the [header] section, keyed by lowercased key */
+	Header map[string]string
+	/* Commands from the [setup] section, relayed to the radio in order */
+	SetupCommands []string
+}
+
+func ParseWaveformConfig(cfgFile io.Reader) (*WaveformConfig, error) {
+	cfg := &WaveformConfig{Header: make(map[string]string)}
+	fileReader := bufio.NewReader(cfgFile)
+	// Find Header section
+	for {
+		line, err := fileReader.ReadString('\n')
+		if err != nil {
+			return nil, errors.New("Hit end of file without finding [header]")
+		}
+		line = st.Trim(line, " \n\r")
+		if st.HasPrefix(st.ToLower(line), "[header]") {
+			break
+		}
+	}
+	// Collect header keys up to the setup section
+	for {
+		line, err := fileReader.ReadString('\n')
+		if err != nil {
+			return nil, errors.New("Hit end of file without finding [setup]")
+		}
+		line = st.Trim(line, " \n\r")
+		if st.HasPrefix(st.ToLower(line), "[setup]") {
+			break
+		}
+		toks := st.SplitN(line, ":", 2)
+		if len(toks) == 2 {
+			key := st.ToLower(st.TrimSpace(toks[0]))
+			cfg.Header[key] = st.Trim(toks[1], " \"")
+		}
+	}
+	cfg.Name = cfg.Header["name"]
+	minVersString, ok := cfg.Header["minimum-smartsdr-version"]
+	if !ok {
+		return nil, errors.New("No minimum-smartsdr-version in [header]")
+	}
+	minVers, err := ParseFlexVersion(minVersString)
+	if err != nil {
+		return nil, err
+	}
+	cfg.MinVersion = minVers
+
+	// Collect setup commands
+	for {
+		line, err := fileReader.ReadString('\n')
+		if err != nil {
+			return nil, errors.New("Hit end of file without finding [end]")
+		}
+		line = st.Trim(line, " \n\r")
+		if st.HasPrefix(st.ToLower(line), "[end]") {
+			break
+		}
+		if len(line) > 0 {
+			cfg.SetupCommands = append(cfg.SetupCommands, line)
+		}
+	}
+	return cfg, nil
+}
+
+func RegisterWaveform(api *SmartAPIInterface, cfgFile io.Reader) error {
+	cfg, err := ParseWaveformConfig(cfgFile)
+	if err != nil {
+		return err
+	}
+	return RegisterWaveformConfig(api, cfg)
+}
+
+/*
+ * Send the setup commands of cfg and subscribe to slice status. Every
+ * command is sent even if one fails; the first failure is returned, as a
+ * *CmdError if the radio rejected the command.
+ */
+func RegisterWaveformConfig(api *SmartAPIInterface, cfg *WaveformConfig) error {
+	fmt.Printf("Minimum version: %s\n", cfg.MinVersion.String())
+	var firstErr error
+	run := func(line string, timeout time.Duration) {
+		a, b, err := api.DoCommand(line, timeout)
+		if err == nil {
+			err = StatusError(b, line, a)
+		}
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			return
+		}
+		fmt.Printf("%x,%s:%s\n", b, a, line)
+	}
+	// Relay setup commands to radio
+	for _, line := range cfg.SetupCommands {
+		run(line, time.Second*1)
+	}
+
+	// Subscribe to slice info
+	run("sub slice all", time.Second*2)
+	return firstErr
+}
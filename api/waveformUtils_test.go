@@ -0,0 +1,78 @@
+/* SPDX-License-Identifier: GPL-3.0
+ *
+ * Copyright (C) 2018 Brady O'Brien. All Rights Reserved.
+ */
+
+package api
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+const testWaveformCfg = `[header]
+Name: FreeDV
+Minimum-SmartSDR-Version: 2.0.0.0
+[setup]
+waveform create name=FreeDV mode=FDV underlying_mode=USB version=2.0.0
+waveform set FreeDV tx=1
+waveform set FreeDV rx_filter low_cut=300
+
+[end]
+`
+
+func TestParseWaveformConfig(t *testing.T) {
+	cfg, err := ParseWaveformConfig(strings.NewReader(testWaveformCfg))
+	if err != nil {
+		t.Fatalf("ParseWaveformConfig: %v", err)
+	}
+	if cfg.Name != "FreeDV" {
+		t.Errorf("name %q, want FreeDV", cfg.Name)
+	}
+	if cfg.MinVersion != (FlexVersion{2, 0, 0, 0}) {
+		t.Errorf("minimum version %s", cfg.MinVersion.String())
+	}
+	if len(cfg.SetupCommands) != 3 {
+		t.Fatalf("got %d setup commands, want 3", len(cfg.SetupCommands))
+	}
+
+	if _, err := ParseWaveformConfig(strings.NewReader("[header]\nName: x\n[setup]\n[end]\n")); err == nil {
+		t.Error("config without a minimum version parsed")
+	}
+	if _, err := ParseWaveformConfig(strings.NewReader("[header]\nName: x\n")); err == nil {
+		t.Error("config without a setup section parsed")
+	}
+}
+
+func TestRegisterWaveformConfig(t *testing.T) {
+	cfg, err := ParseWaveformConfig(strings.NewReader(testWaveformCfg))
+	if err != nil {
+		t.Fatalf("ParseWaveformConfig: %v", err)
+	}
+	mock := NewMockRadio(testVersion, 1)
+	/* A failing setup command is returned but doesn't stop the rest */
+	mock.Respond("waveform set FreeDV tx", SL_BAD_COMMAND, "")
+	api := connectMock(t, mock)
+
+	err = RegisterWaveformConfig(api, cfg)
+	var cmdErr *CmdError
+	if !errors.As(err, &cmdErr) || cmdErr.Code != SL_BAD_COMMAND || cmdErr.Command != "waveform set FreeDV tx=1" {
+		t.Fatalf("RegisterWaveformConfig returned %v, want the failed setup command", err)
+	}
+	want := append(append([]string(nil), cfg.SetupCommands...), "sub slice all")
+	if got := mock.Received(); strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("radio received\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestRegisterWaveformConfigSucceeds(t *testing.T) {
+	cfg, err := ParseWaveformConfig(strings.NewReader(testWaveformCfg))
+	if err != nil {
+		t.Fatalf("ParseWaveformConfig: %v", err)
+	}
+	api := connectMock(t, NewMockRadio(testVersion, 1))
+	if err := RegisterWaveformConfig(api, cfg); err != nil {
+		t.Errorf("RegisterWaveformConfig: %v", err)
+	}
+}
@@ -0,0 +1,356 @@
+/* SPDX-License-Identifier: GPL-3.0
+ *
+ * Copyright (C) 2018 Brady O'Brien. All Rights Reserved.
+ */
+
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/baobrien/smartsdr-golang/api"
+	"github.com/baobrien/smartsdr-golang/discovery"
+	"github.com/baobrien/smartsdr-golang/dsp"
+	"github.com/baobrien/smartsdr-golang/freedv"
+	"github.com/baobrien/smartsdr-golang/vita"
+	"github.com/baobrien/smartsdr-golang/waveform"
+	"golang.org/x/sync/errgroup"
+)
+
+/* How long shutdown may spend unregistering and draining before giving up */
+const SHUTDOWN_TIMEOUT = 3 * time.Second
+
+/* Report a fatal error; returns the exit status for run */
+func topError(err error) int {
+	fmt.Printf("Error in main: %v\n", err)
+	return 1
+}
+
+func StartVitaEchoer(vif *vita.VitaInterface) {
+	ch := make([]chan []float32, 5)
+	for v := range ch {
+		ch[v] = make(chan []float32, 2)
+	}
+	chp := 0
+
+	/* Add vita to []float input thing */
+	vif.Subscribers[waveform.WAVEFORM_RX_STREAM_ID] = dsp.StVitaInputF(ch[chp])
+
+	go dsp.SampCtrF(ch[chp], ch[chp+1], "RX In ", time.Second)
+	chp++
+
+	/* Start 24Khz to 8Khz stage */
+	go dsp.StResamp24to8F(ch[chp], ch[chp+1], 256)
+	chp++
+
+	/* Start 8Khz to 24Khz stage */
+	go dsp.StResamp8to24F(ch[chp], ch[chp+1], 256)
+	chp++
+
+	go dsp.SampCtrF(ch[chp], ch[chp+1], "RX Out", time.Second)
+	chp++
+
+	templateHeader := &vita.VitaIfDataHeader{
+		StreamID:       waveform.WAVEFORM_RX_STREAM_ID,
+		ClassIDH:       0x00001C2D,
+		ClassIDL:       vita.SL_VITA_SLICE_AUDIO_CLASS,
+		TimestampFracH: 0,
+		TimestampFracL: 0,
+		TimestampInt:   0,
+	}
+	go dsp.StVitaOutputF(ch[chp], vif, templateHeader)
+
+}
+
+func StartVitaEchoer2(vif *vita.VitaInterface) {
+	ch0 := make(chan []float32, 2)
+	ch1 := make(chan []float32, 2)
+	ch2 := make(chan []float32, 2)
+	ch3 := make(chan []float32, 2)
+	ch4 := make(chan []float32, 2)
+	ch5 := make(chan []float32, 2)
+	ch6 := make(chan []float32, 2)
+
+	/* Add vita to []float input thing */
+	vif.Subscribers[waveform.WAVEFORM_RX_STREAM_ID] = dsp.StVitaInputF(ch0)
+
+	dsp.StDelatentizerF(ch0, ch1, ch5, ch6, 127)
+
+	go dsp.SampCtrF(ch1, ch2, "RX In ", time.Second)
+
+	go dsp.StAccumulatorF(ch2, ch3, 20000)
+
+	go dsp.SampCtrF(ch3, ch4, "RX Out", time.Second)
+
+	go dsp.StAccumulatorF(ch4, ch5, 128)
+
+	templateHeader := &vita.VitaIfDataHeader{
+		StreamID:       waveform.WAVEFORM_RX_STREAM_ID,
+		ClassIDH:       0x00001C2D,
+		ClassIDL:       vita.SL_VITA_SLICE_AUDIO_CLASS,
+		TimestampFracH: 0,
+		TimestampFracL: 0,
+		TimestampInt:   0,
+	}
+	go dsp.StVitaOutputF(ch6, vif, templateHeader)
+
+}
+
+func main() {
+	/* run returns only after its deferred cleanup, so exit here */
+	os.Exit(run())
+}
+
+func run() int {
+	serial := flag.String("serial", "", "serial number of radio to attach to")
+	nickname := flag.String("nickname", "", "nickname of radio to attach to")
+	callsign := flag.String("callsign", "", "callsign of radio to attach to")
+	model := flag.String("model", "", "model of radio to attach to")
+	transcript := flag.String("transcript", "", "record the radio API session to this file")
+	flag.Parse()
+
+	/* Everything below runs until SIGINT/SIGTERM or the first fatal error */
+	ctx, stopSignals := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stopSignals()
+
+	/* Build a matcher from whichever radio selectors were given */
+	matchers := []discovery.RadioMatcher{}
+	if *serial != "" {
+		matchers = append(matchers, discovery.MatchSerial(*serial))
+	}
+	if *nickname != "" {
+		matchers = append(matchers, discovery.MatchNickname(*nickname))
+	}
+	if *callsign != "" {
+		matchers = append(matchers, discovery.MatchCallsign(*callsign))
+	}
+	if *model != "" {
+		matchers = append(matchers, discovery.MatchModel(*model))
+	}
+
+	/* Discover a radio */
+	registry, err := discovery.StartRadioRegistry()
+	if err != nil {
+		return topError(err)
+	}
+	defer registry.Close()
+	radio, err := registry.WaitForRadio(discovery.MatchAll(matchers...), 10*time.Second)
+	if err != nil {
+		return topError(err)
+	}
+	go func() {
+		for ev := range registry.Events {
+			fmt.Println("Radio", ev.Type.String()+":", ev.Radio)
+		}
+	}()
+
+	fmt.Println("Found radio:", radio)
+
+	/* Read waveform configuration file; it is replayed on every connect */
+	configFile, err := os.Open("FreeDV.cfg")
+	if err != nil {
+		return topError(err)
+	}
+	cfg, err := api.ParseWaveformConfig(configFile)
+	configFile.Close()
+	if err != nil {
+		return topError(err)
+	}
+
+	/* Connect to radio and keep the API session alive */
+	mgr := api.NewConnectionManager(radio.APIAddr(), cfg)
+	defer mgr.Close()
+	if *transcript != "" {
+		rec, err := api.CreateTranscriptFile(*transcript)
+		if err != nil {
+			return topError(err)
+		}
+		defer rec.Close()
+		mgr.Transcript = rec
+	}
+	/* Register status handler to print all status messages */
+	mgr.RegisterStatusHandler("", func(handle uint32, status string) {
+		fmt.Println(status)
+	})
+	/* Keep a typed view of radio state and report slice mode changes */
+	status := api.NewStatusStore()
+	mgr.RegisterStatusHandler("", status.HandleStatus)
+	status.Subscribe(func(change *api.StatusChange) {
+		if mode, ok := change.Changed["mode"]; ok && change.Object == "slice" {
+			fmt.Printf("Slice %d mode changed to %s\n", change.Index, mode.New)
+		}
+	})
+
+	/* Log operator-visible radio messages */
+	mgr.SubscribeMessages(func(msg *api.RadioMessage) {
+		fmt.Println("Radio message", msg)
+	})
+	group, gctx := errgroup.WithContext(ctx)
+	group.Go(mgr.Run)
+
+	/* Simple loop to print API errors */
+	go func() {
+		for {
+			err := <-mgr.Errors
+			fmt.Println(err)
+		}
+	}()
+
+	/* Wait for the first session to come up */
+	fmt.Println("Setting up Waveform:")
+	for connected := false; !connected; {
+		select {
+		case state := <-mgr.StateChanges:
+			connected = state == api.CONN_CONNECTED
+			if state == api.CONN_CLOSED {
+				err := group.Wait()
+				if err == nil {
+					err = errors.New("Could not set up waveform on radio")
+				}
+				return topError(err)
+			}
+		case <-gctx.Done():
+			mgr.Close()
+			if err := group.Wait(); err != nil {
+				return topError(err)
+			}
+			return 0
+		}
+	}
+	go func() {
+		for state := range mgr.StateChanges {
+			fmt.Println("Radio connection", state)
+		}
+	}()
+
+	/* Set up VITA stream handler */
+	connVitaLocal, err := net.ResolveUDPAddr("udp", "0.0.0.0:4999")
+	if err != nil {
+		return topError(err)
+	}
+	connVitaRadio, err := net.ResolveUDPAddr("udp", radio.IP.String()+":4991")
+	if err != nil {
+		return topError(err)
+	}
+
+	vitaListener, err := vita.InitVitaListener(connVitaLocal, connVitaRadio)
+	if err != nil {
+		return topError(err)
+	}
+	defer vitaListener.Close()
+
+	/*vitaListener.Subscribers[0x81000000] = func(pkt *VitaIFData, pool *VitaBufferPool) {
+		fmt.Println("Got VITA49 Packet. Samples: ", len(pkt.DataBytes)/8)
+		pool.ReleasePB(pkt.RawPacketBuffer, pkt)
+	}*/
+
+	fdv, err := freedv.FreedvOpen(freedv.FREEDV_MODE_700C)
+	if err != nil {
+		return topError(err)
+	}
+	/* Safe even if a stage failed to drain; a closed modem does nothing */
+	defer fdv.Close()
+	/* Let the GUI switch modes through "slice N waveform_cmd mode=..." */
+	fdvCtl := waveform.NewFreedvController(fdv, mgr)
+	mgr.RegisterCommandHandler("slice", fdvCtl.HandleSliceCommand)
+	/* Identify with the radio's callsign, and log what others send */
+	fdv.SetTxText(radio.Callsign)
+	go func() {
+		for line := range fdvCtl.RxText {
+			fmt.Println("FreeDV text:", line)
+		}
+	}()
+	rxer := waveform.StartFdvRxer(vitaListener, fdv)
+	txer := waveform.StartFdvTxer(vitaListener, fdv)
+	/* Follow the radio's own meters */
+	radioMeters := waveform.NewRadioMeters(api.NewMeterRegistry())
+	radioMeters.Attach(mgr, vitaListener)
+
+	listenDone := make(chan int)
+	group.Go(func() error {
+		defer close(listenDone)
+		return vitaListener.VitaListenLoop(gctx)
+	})
+	/* The sender outlives gctx so the pipelines can drain into it */
+	sendCtx, stopSender := context.WithCancel(context.Background())
+	defer stopSender()
+	group.Go(func() error {
+		return vitaListener.VitaSenderLoop(sendCtx)
+	})
+
+	/* Report SNR and sync to the radio */
+	meters := waveform.NewWaveformMeters(mgr, vitaListener)
+	reportQuit := make(chan int)
+	var reporters sync.WaitGroup
+	reporters.Add(3)
+	go func() {
+		fdvCtl.StatsLoop(waveform.FREEDV_STATS_INTERVAL, meters, reportQuit)
+		reporters.Done()
+	}()
+	/* Tell the radio what format our streams are in */
+	go func() {
+		waveform.AdvertiseStreams(vitaListener, waveform.STREAM_CONTEXT_INTERVAL, reportQuit, rxer, txer)
+		reporters.Done()
+	}()
+	/* Log trouble on the streams the radio sends us */
+	go func() {
+		waveform.ReportStreams(waveform.STREAM_REPORT_INTERVAL, reportQuit, rxer, txer)
+		reporters.Done()
+	}()
+	reportDone := make(chan int)
+	go func() {
+		reporters.Wait()
+		close(reportDone)
+	}()
+
+	/*
+	 * Shut down in order once signalled or something fails: take the
+	 * waveform off the radio, stop taking input, drain the pipelines into
+	 * the sender, then let the sender finish and drop the API session.
+	 */
+	group.Go(func() error {
+		<-gctx.Done()
+		fmt.Println("Shutting down")
+		shutCtx, cancel := context.WithTimeout(context.Background(), SHUTDOWN_TIMEOUT)
+		defer cancel()
+		defer stopSender()
+		defer mgr.Close()
+
+		if err := mgr.RemoveWaveform(shutCtx); err != nil {
+			fmt.Println("Could not remove waveform:", err)
+		}
+		close(reportQuit)
+		select {
+		case <-reportDone:
+		case <-shutCtx.Done():
+			return shutCtx.Err()
+		}
+		<-listenDone
+		if err := rxer.Stop(shutCtx); err != nil {
+			return err
+		}
+		if err := txer.Stop(shutCtx); err != nil {
+			return err
+		}
+		select {
+		case vitaListener.SendChannel <- nil:
+		case <-shutCtx.Done():
+			return shutCtx.Err()
+		}
+		return nil
+	})
+
+	if err := group.Wait(); err != nil {
+		return topError(err)
+	}
+	return 0
+}
@@ -6,52 +6,33 @@
  * Utility to discover FlexRadio devices using the VITA-49 based discovery protocol
  */
 
-package main
+package discovery
 
 import (
 	"fmt"
 	"os"
+
+	"github.com/baobrien/smartsdr-golang/api"
+	"github.com/baobrien/smartsdr-golang/vita"
 )
 import (
 	"errors"
 	"net"
+	st "strings"
+	"sync"
 	"time"
 )
 
-type Radio struct {
-	discoveryProtocolVersion string
-	model                    string
-	serial                   string
-	version                  string
-	nickname                 string
-	ip                       string
-	port                     string
-	status                   string
-	callsign                 string
-}
-
 const DISCOVERY_PORT = 4992
 
 type DiscoveryClient struct {
 	errors    chan error
 	radios    chan *Radio
-	quit      chan int
+	quit      chan int /* Closed by Close; every send also waits on it */
+	closeOnce sync.Once
 	udplisten net.PacketConn
 }
 
-func (radio *Radio) String() string {
-	return fmt.Sprintf("discovery_protocol_version=%s model=%s serial=%s version=%s nickname=%s callsign=%s ip=%s port=%s status=%s\n",
-		radio.discoveryProtocolVersion,
-		radio.model,
-		radio.serial,
-		radio.version,
-		radio.nickname,
-		radio.callsign,
-		radio.ip,
-		radio.port,
-		radio.status)
-}
-
 func CreateDiscoveryClient(addr *net.UDPAddr) (*DiscoveryClient, error) {
 	discli := &DiscoveryClient{
 		errors: make(chan error),
@@ -80,86 +61,60 @@ func parseDiscoveryPacket(buf []byte) (*Radio, error) {
 	if len(buf) < 28 {
 		return nil, errors.New("parseDiscoveryPacket: packet too short")
 	}
-	v := &VitaIfDataHeader{}
+	v := &vita.VitaIfDataHeader{}
 
-	ReadVitaHeader(buf, v)
+	vita.ReadVitaHeader(buf, v)
 
 	if v.ClassIDH != 0x00001C2D {
 		return nil, errors.New(fmt.Sprintf("parseDiscoveryPacket: Wrong OUI %08x", v.ClassIDH))
 	}
-	if (v.Header & VITA_HEADER_PACKET_TYPE_MASK) != VITA_PACKET_TYPE_EXT_DATA_WITH_STREAM_ID {
+	if (v.Header & vita.VITA_HEADER_PACKET_TYPE_MASK) != vita.VITA_PACKET_TYPE_EXT_DATA_WITH_STREAM_ID {
 		return nil, errors.New(fmt.Sprintf("parseDiscoveryPacket: Wrong Packet Type %08x", v.Header))
 	}
-	if (v.ClassIDL & VITA_CLASS_ID_PACKET_CLASS_MASK) != 0xFFFF {
+	if (v.ClassIDL & vita.VITA_CLASS_ID_PACKET_CLASS_MASK) != 0xFFFF {
 		return nil, errors.New(fmt.Sprintf("parseDiscoveryPacket: Wrong class %08x", v.ClassIDH))
 	}
 
-	radio := &Radio{}
-	discstr := string(buf[28:])
-	for k, v := range detokenize(discstr) {
-		switch k {
-		case "discovery_protocol_version":
-			radio.discoveryProtocolVersion = v
-		case "model":
-			radio.model = v
-		case "serial":
-			radio.serial = v
-		case "nickname":
-			radio.nickname = v
-		case "ip":
-			radio.ip = v
-		case "port":
-			radio.port = v
-		case "status":
-			radio.status = v
-		case "version":
-			radio.version = v
-		case "callsign":
-			radio.callsign = v
-		}
-	}
-	return radio, nil
+	/* Payload is padded out to a whole word with NULs */
+	discstr := st.TrimRight(string(buf[28:]), "\x00")
+	return radioFromTokens(api.Tokenize(discstr).Values), nil
 }
 
 func (discli *DiscoveryClient) doDiscoveryListen() {
+	defer discli.udplisten.Close()
 	buf := make([]byte, 1500)
 	for {
+		n, _, err := discli.udplisten.ReadFrom(buf)
+		if err == nil && n == 0 {
+			err = errors.New("Got EOF in UDP listener")
+		}
+		if err != nil {
+			/* Closing the socket is how Close wakes us; that's no error */
+			select {
+			case <-discli.quit:
+			case discli.errors <- err:
+			}
+			return
+		}
+		r, err := parseDiscoveryPacket(buf[:n])
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			continue
+		}
 		select {
+		case discli.radios <- r:
 		case <-discli.quit:
-			discli.udplisten.Close()
 			return
-		case <-func() chan int {
-			dc := make(chan int)
-			go func() {
-				n, _, err := discli.udplisten.ReadFrom(buf)
-				if err != nil {
-					discli.errors <- err
-					discli.quit <- 1
-				}
-				if n == 0 {
-					discli.errors <- errors.New("Got EOF in UDP listener")
-					discli.quit <- 1
-				}
-				dc <- n
-			}()
-			return dc
-		}():
-			r, err := parseDiscoveryPacket(buf)
-			if err != nil {
-				fmt.Fprintln(os.Stderr, err)
-			} else {
-				discli.radios <- r
-			}
 		}
 	}
 }
 
+/* Stop listening. Safe to call more than once, and from several goroutines */
 func (discli *DiscoveryClient) Close() {
-	// Send on quit channel if client is listening on the other end
-	select {
-	case discli.quit <- 1:
-	default:
-	}
+	discli.closeOnce.Do(func() {
+		close(discli.quit)
+		discli.udplisten.Close()
+	})
 }
 
 /*
@@ -0,0 +1,144 @@
+/* SPDX-License-Identifier: GPL-3.0
+ *
+ * Copyright (C) 2018 Brady OBrien. All Rights Reserved.
+ *
+ * Emit discovery broadcasts on behalf of a radio so the discovery path
+ * can be exercised without hardware
+ */
+
+package discovery
+
+import (
+	b "encoding/binary"
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/baobrien/smartsdr-golang/vita"
+)
+
+const DISCOVERY_STREAM_ID uint32 = 0x00000800
+const DISCOVERY_CLASS_ID_H uint32 = 0x00001C2D
+const DISCOVERY_CLASS_ID_L uint32 = (vita.SL_VITA_INFO_CLASS << 16) | 0xFFFF
+
+const DEFAULT_BEACON_INTERVAL = time.Second
+
+type DiscoveryBeacon struct {
+	conn     *net.UDPConn
+	lock     sync.Mutex
+	radio    *Radio
+	interval time.Duration
+	seq      uint32
+	quit     chan int
+}
+
+/*
+ * Pack a discovery packet describing radio into buffer and return the
+ * number of bytes used
+ */
+func PackDiscoveryPacket(radio *Radio, buffer []byte, seq uint32) (int, error) {
+	payload := []byte(radio.discoveryTokens())
+	/* Payload is NUL padded out to a whole word */
+	payloadWords := (len(payload) + 3) / 4
+	packetBytes := (7 + payloadWords) * 4
+	if packetBytes > len(buffer) {
+		return 0, errors.New("PackDiscoveryPacket: radio description too long")
+	}
+
+	var hdrWord uint32 = vita.VITA_PACKET_TYPE_EXT_DATA_WITH_STREAM_ID
+	hdrWord |= vita.VITA_HEADER_CLASS_ID_PRESENT
+	hdrWord |= vita.VITA_TSI_OTHER
+	hdrWord |= vita.VITA_TSF_SAMPLE_COUNT
+	hdrWord |= (seq & 0xF) << 16
+	hdrWord |= uint32(7 + payloadWords)
+
+	b.BigEndian.PutUint32(buffer[:], hdrWord)
+	b.BigEndian.PutUint32(buffer[4:], DISCOVERY_STREAM_ID)
+	b.BigEndian.PutUint32(buffer[8:], DISCOVERY_CLASS_ID_H)
+	b.BigEndian.PutUint32(buffer[12:], DISCOVERY_CLASS_ID_L)
+	b.BigEndian.PutUint32(buffer[16:], 0)
+	b.BigEndian.PutUint32(buffer[20:], 0)
+	b.BigEndian.PutUint32(buffer[24:], 0)
+	n := copy(buffer[28:], payload)
+	for i := 28 + n; i < packetBytes; i++ {
+		buffer[i] = 0
+	}
+	return packetBytes, nil
+}
+
+/*
+ * Create a beacon which broadcasts radio to addr every interval.
+ * A nil addr broadcasts on the default discovery port.
+ */
+func CreateDiscoveryBeacon(radio *Radio, addr *net.UDPAddr, interval time.Duration) (*DiscoveryBeacon, error) {
+	if addr == nil {
+		addr = &net.UDPAddr{IP: net.IPv4bcast, Port: DISCOVERY_PORT}
+	}
+	if interval <= 0 {
+		interval = DEFAULT_BEACON_INTERVAL
+	}
+	conn, err := net.DialUDP("udp", nil, addr)
+	if err != nil {
+		return nil, err
+	}
+	beacon := &DiscoveryBeacon{
+		conn:     conn,
+		radio:    radio,
+		interval: interval,
+		quit:     make(chan int),
+	}
+	return beacon, nil
+}
+
+/* Replace the advertised radio; takes effect on the next broadcast */
+func (beacon *DiscoveryBeacon) SetRadio(radio *Radio) {
+	beacon.lock.Lock()
+	beacon.radio = radio
+	beacon.lock.Unlock()
+}
+
+/* Send a single discovery packet */
+func (beacon *DiscoveryBeacon) SendOnce() error {
+	buf := make([]byte, vita.MAX_PACKET_LEN)
+	beacon.lock.Lock()
+	n, err := PackDiscoveryPacket(beacon.radio, buf, beacon.seq)
+	beacon.seq++
+	beacon.lock.Unlock()
+	if err != nil {
+		return err
+	}
+	_, err = beacon.conn.Write(buf[:n])
+	return err
+}
+
+/* Broadcast until Close is called or a send fails */
+func (beacon *DiscoveryBeacon) BeaconLoop() error {
+	ticker := time.NewTicker(beacon.interval)
+	defer ticker.Stop()
+	for {
+		if err := beacon.SendOnce(); err != nil {
+			select {
+			case <-beacon.quit:
+				return nil
+			default:
+				return err
+			}
+		}
+		select {
+		case <-beacon.quit:
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+func (beacon *DiscoveryBeacon) Close() {
+	select {
+	case <-beacon.quit:
+		return
+	default:
+	}
+	close(beacon.quit)
+	beacon.conn.Close()
+}
@@ -3,7 +3,7 @@
  * Copyright (C) 2018 Brady O'Brien. All Rights Reserved.
  */
 
-package main
+package discovery
 
 import (
 	"net"
@@ -0,0 +1,250 @@
+/* SPDX-License-Identifier: GPL-3.0
+ *
+ * Copyright (C) 2018 Brady OBrien. All Rights Reserved.
+ */
+
+package discovery
+
+import (
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/baobrien/smartsdr-golang/api"
+	"github.com/baobrien/smartsdr-golang/vita"
+)
+
+const testTimeout = 2 * time.Second
+
+func testRadio() *Radio {
+	return &Radio{
+		DiscoveryProtocolVersion: api.FlexVersion{Major: 3, DevB: 1},
+		Model:                    "FLEX-6600",
+		Serial:                   "1234-5678-9012-3456",
+		Version:                  api.FlexVersion{Major: 2, Minor: 4, DevA: 9, DevB: 128},
+		MinSoftwareVersion:       api.FlexVersion{Major: 2},
+		Nickname:                 "Shack",
+		Callsign:                 "N0CALL",
+		IP:                       net.ParseIP("192.168.1.20"),
+		Port:                     4992,
+		Status:                   "Available",
+		MaxLicensedVersion:       "v2",
+		RadioLicenseID:           "00-1C-2D-05-04-00",
+		FpcMac:                   "",
+		LicensedClients:          2,
+		AvailableClients:         2,
+		MaxPanadapters:           4,
+		AvailablePanadapters:     4,
+		MaxSlices:                4,
+		AvailableSlices:          4,
+		Extra:                    map[string]string{},
+	}
+}
+
+var discoveryPacketTests = []struct {
+	name  string
+	radio func() *Radio
+}{
+	{"idle radio", testRadio},
+	{"spaces and escapes", func() *Radio {
+		r := testRadio()
+		r.Nickname = "Field Day rig"
+		r.Status = "In Use"
+		r.Callsign = "N0CALL/P"
+		return r
+	}},
+	{"in use", func() *Radio {
+		r := testRadio()
+		r.InUseIP = net.ParseIP("192.168.1.30")
+		r.InUseHost = "shack-pc"
+		r.WanConnected = true
+		r.RequiresAdditionalLicense = true
+		r.AvailableSlices = 2
+		return r
+	}},
+	{"gui clients", func() *Radio {
+		r := testRadio()
+		r.GuiClients = []GuiClient{
+			{Handle: 0x1234ABCD, IP: net.ParseIP("192.168.1.30"), Host: "shack-pc", Program: "SmartSDR-Win", Station: "Shack"},
+			{Handle: 0x00000042, IP: net.ParseIP("192.168.1.31"), Host: "laptop", Program: "Maestro", Station: "Porch"},
+		}
+		return r
+	}},
+	{"unknown keys", func() *Radio {
+		r := testRadio()
+		r.Extra = map[string]string{"turf_region": "USA", "external_port_link": "1"}
+		return r
+	}},
+}
+
+func TestDiscoveryPacketRoundTrip(t *testing.T) {
+	for _, tc := range discoveryPacketTests {
+		t.Run(tc.name, func(t *testing.T) {
+			radio := tc.radio()
+			buf := make([]byte, vita.MAX_PACKET_LEN)
+			n, err := PackDiscoveryPacket(radio, buf, 3)
+			if err != nil {
+				t.Fatalf("PackDiscoveryPacket: %v", err)
+			}
+			if n%4 != 0 {
+				t.Errorf("packet is %d bytes, not a whole number of words", n)
+			}
+			got, err := parseDiscoveryPacket(buf[:n])
+			if err != nil {
+				t.Fatalf("parseDiscoveryPacket: %v", err)
+			}
+			if !got.Equal(radio) {
+				t.Errorf("round trip\n got %+v\nwant %+v", got, radio)
+			}
+		})
+	}
+}
+
+func TestDiscoveryPacketErrors(t *testing.T) {
+	buf := make([]byte, vita.MAX_PACKET_LEN)
+	if _, err := PackDiscoveryPacket(testRadio(), buf[:64], 0); err == nil {
+		t.Error("packed a radio into a buffer too small for it")
+	}
+	n, err := PackDiscoveryPacket(testRadio(), buf, 0)
+	if err != nil {
+		t.Fatalf("PackDiscoveryPacket: %v", err)
+	}
+	if _, err := parseDiscoveryPacket(buf[:20]); err == nil {
+		t.Error("parsed a truncated header")
+	}
+	for _, tc := range []struct {
+		name   string
+		offset int
+		value  byte
+	}{
+		{"packet type", 0, 0x10},
+		{"OUI", 11, 0x2E},
+		{"packet class", 15, 0xFE},
+	} {
+		bad := append([]byte(nil), buf[:n]...)
+		bad[tc.offset] = tc.value
+		if _, err := parseDiscoveryPacket(bad); err == nil {
+			t.Errorf("parsed a packet with the wrong %s", tc.name)
+		}
+	}
+}
+
+/* A registry listening on a loopback port the kernel picks */
+func startTestRegistry(t *testing.T, expiry time.Duration) (*RadioRegistry, *net.UDPAddr) {
+	t.Helper()
+	reg, err := CreateRadioRegistry(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)}, expiry)
+	if err != nil {
+		t.Fatalf("CreateRadioRegistry: %v", err)
+	}
+	t.Cleanup(reg.Close)
+	return reg, reg.client.udplisten.LocalAddr().(*net.UDPAddr)
+}
+
+func startTestBeacon(t *testing.T, radio *Radio, addr *net.UDPAddr) *DiscoveryBeacon {
+	t.Helper()
+	beacon, err := CreateDiscoveryBeacon(radio, addr, 10*time.Millisecond)
+	if err != nil {
+		t.Fatalf("CreateDiscoveryBeacon: %v", err)
+	}
+	go beacon.BeaconLoop()
+	t.Cleanup(beacon.Close)
+	return beacon
+}
+
+func nextEvent(t *testing.T, reg *RadioRegistry) *RadioEvent {
+	t.Helper()
+	select {
+	case ev, ok := <-reg.Events:
+		if !ok {
+			t.Fatal("registry closed its events")
+		}
+		return ev
+	case <-time.After(testTimeout):
+		t.Fatal("no registry event")
+	}
+	return nil
+}
+
+/* A beacon on loopback is heard, updated, and expired by the registry */
+func TestRegistryLoopback(t *testing.T) {
+	reg, addr := startTestRegistry(t, 100*time.Millisecond)
+	radio := testRadio()
+	beacon := startTestBeacon(t, radio, addr)
+
+	if ev := nextEvent(t, reg); ev.Type != RADIO_ADDED || !ev.Radio.Equal(radio) {
+		t.Fatalf("first event %s %v, want added %v", ev.Type.String(), ev.Radio, radio)
+	}
+	found, err := reg.WaitForRadio(MatchSerial(radio.Serial), testTimeout)
+	if err != nil || !found.Equal(radio) {
+		t.Fatalf("WaitForRadio: %v %v", found, err)
+	}
+
+	changed := testRadio()
+	changed.AvailableSlices = 1
+	beacon.SetRadio(changed)
+	if ev := nextEvent(t, reg); ev.Type != RADIO_CHANGED || !ev.Radio.Equal(changed) {
+		t.Fatalf("event %s %v, want changed %v", ev.Type.String(), ev.Radio, changed)
+	}
+
+	beacon.Close()
+	if ev := nextEvent(t, reg); ev.Type != RADIO_REMOVED || ev.Radio.Serial != radio.Serial {
+		t.Fatalf("event %s %v, want removed", ev.Type.String(), ev.Radio)
+	}
+	if radios := reg.Radios(); len(radios) != 0 {
+		t.Errorf("%d radios left after expiry", len(radios))
+	}
+}
+
+/* Close returns even with the listener stuck handing over a radio, and may race with itself */
+func TestClientCloseWhileSending(t *testing.T) {
+	client, err := CreateDiscoveryClient(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("CreateDiscoveryClient: %v", err)
+	}
+	listenDone := make(chan int)
+	go func() {
+		client.doDiscoveryListen()
+		close(listenDone)
+	}()
+	/* Nobody reads client.radios, so the listener blocks on the first beacon */
+	beacon := startTestBeacon(t, testRadio(), client.udplisten.LocalAddr().(*net.UDPAddr))
+	if err := beacon.SendOnce(); err != nil {
+		t.Fatalf("SendOnce: %v", err)
+	}
+	time.Sleep(20 * time.Millisecond)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			client.Close()
+		}()
+	}
+	wg.Wait()
+	select {
+	case <-listenDone:
+	case <-time.After(testTimeout):
+		t.Fatal("listener still running after Close")
+	}
+}
+
+func TestRegistryCloseConcurrent(t *testing.T) {
+	reg, _ := startTestRegistry(t, time.Second)
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			reg.Close()
+		}()
+	}
+	wg.Wait()
+	if _, ok := <-reg.Events; ok {
+		t.Error("events still open after Close")
+	}
+	if _, err := reg.WaitForRadio(MatchAny(), testTimeout); err == nil {
+		t.Error("WaitForRadio found a radio on a closed registry")
+	}
+}
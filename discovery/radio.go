@@ -0,0 +1,283 @@
+/* SPDX-License-Identifier: GPL-3.0
+ *
+ * Copyright (C) 2018 Brady OBrien. All Rights Reserved.
+ *
+ * Model of a radio as described by its discovery broadcasts
+ */
+
+package discovery
+
+import (
+	"fmt"
+	"net"
+	"reflect"
+	"sort"
+	"strconv"
+	st "strings"
+
+	"github.com/baobrien/smartsdr-golang/api"
+)
+
+/* One client attached to the radio, taken from the gui_client_* lists */
+type GuiClient struct {
+	Handle  uint32
+	IP      net.IP
+	Host    string
+	Program string
+	Station string
+}
+
+type Radio struct {
+	DiscoveryProtocolVersion  api.FlexVersion
+	Model                     string
+	Serial                    string
+	Version                   api.FlexVersion
+	MinSoftwareVersion        api.FlexVersion
+	Nickname                  string
+	Callsign                  string
+	IP                        net.IP
+	Port                      int
+	Status                    string
+	InUseIP                   net.IP
+	InUseHost                 string
+	MaxLicensedVersion        string
+	RadioLicenseID            string
+	RequiresAdditionalLicense bool
+	FpcMac                    string
+	WanConnected              bool
+	LicensedClients           int
+	AvailableClients          int
+	MaxPanadapters            int
+	AvailablePanadapters      int
+	MaxSlices                 int
+	AvailableSlices           int
+	GuiClients                []GuiClient
+	/* Keys this model does not know about, kept verbatim */
+	Extra map[string]string
+}
+
+func parseDiscoveryBool(v string) bool {
+	b, err := strconv.ParseBool(v)
+	return err == nil && b
+}
+
+func parseDiscoveryInt(v string) int {
+	i, _ := strconv.Atoi(v)
+	return i
+}
+
+func splitDiscoveryList(v string) []string {
+	if v == "" {
+		return nil
+	}
+	return st.Split(v, ",")
+}
+
+/* Zip the parallel gui_client_* lists into per-client records */
+func parseGuiClients(tokens map[string]string) []GuiClient {
+	handles := splitDiscoveryList(tokens["gui_client_handles"])
+	ips := splitDiscoveryList(tokens["gui_client_ips"])
+	hosts := splitDiscoveryList(tokens["gui_client_hosts"])
+	programs := splitDiscoveryList(tokens["gui_client_programs"])
+	stations := splitDiscoveryList(tokens["gui_client_stations"])
+
+	nclients := 0
+	for _, l := range [][]string{handles, ips, hosts, programs, stations} {
+		if len(l) > nclients {
+			nclients = len(l)
+		}
+	}
+	if nclients == 0 {
+		return nil
+	}
+	at := func(l []string, i int) string {
+		if i < len(l) {
+			return l[i]
+		}
+		return ""
+	}
+	clients := make([]GuiClient, nclients)
+	for i := range clients {
+		handle, _ := strconv.ParseUint(st.TrimPrefix(at(handles, i), "0x"), 16, 32)
+		clients[i] = GuiClient{
+			Handle:  uint32(handle),
+			IP:      net.ParseIP(at(ips, i)),
+			Host:    at(hosts, i),
+			Program: at(programs, i),
+			Station: at(stations, i),
+		}
+	}
+	return clients
+}
+
+/* Build a Radio from the key/value pairs of a discovery packet */
+func radioFromTokens(tokens map[string]string) *Radio {
+	radio := &Radio{Extra: make(map[string]string)}
+	for k, v := range tokens {
+		switch k {
+		case "discovery_protocol_version":
+			radio.DiscoveryProtocolVersion, _ = api.ParseFlexVersion(v)
+		case "model":
+			radio.Model = v
+		case "serial":
+			radio.Serial = v
+		case "version":
+			radio.Version, _ = api.ParseFlexVersion(v)
+		case "min_software_version":
+			radio.MinSoftwareVersion, _ = api.ParseFlexVersion(v)
+		case "nickname":
+			radio.Nickname = v
+		case "callsign":
+			radio.Callsign = v
+		case "ip":
+			radio.IP = net.ParseIP(v)
+		case "port":
+			radio.Port = parseDiscoveryInt(v)
+		case "status":
+			radio.Status = v
+		case "inuse_ip":
+			radio.InUseIP = net.ParseIP(v)
+		case "inuse_host":
+			radio.InUseHost = v
+		case "max_licensed_version":
+			radio.MaxLicensedVersion = v
+		case "radio_license_id":
+			radio.RadioLicenseID = v
+		case "requires_additional_license":
+			radio.RequiresAdditionalLicense = parseDiscoveryBool(v)
+		case "fpc_mac":
+			radio.FpcMac = v
+		case "wan_connected":
+			radio.WanConnected = parseDiscoveryBool(v)
+		case "licensed_clients":
+			radio.LicensedClients = parseDiscoveryInt(v)
+		case "available_clients":
+			radio.AvailableClients = parseDiscoveryInt(v)
+		case "max_panadapters":
+			radio.MaxPanadapters = parseDiscoveryInt(v)
+		case "available_panadapters":
+			radio.AvailablePanadapters = parseDiscoveryInt(v)
+		case "max_slices":
+			radio.MaxSlices = parseDiscoveryInt(v)
+		case "available_slices":
+			radio.AvailableSlices = parseDiscoveryInt(v)
+		case "gui_client_handles", "gui_client_ips", "gui_client_hosts",
+			"gui_client_programs", "gui_client_stations":
+			/* Handled together below */
+		default:
+			radio.Extra[k] = v
+		}
+	}
+	radio.GuiClients = parseGuiClients(tokens)
+	return radio
+}
+
+func formatDiscoveryBool(b bool) string {
+	if b {
+		return "1"
+	}
+	return "0"
+}
+
+/* Render the radio as the key/value payload of a discovery packet */
+func (radio *Radio) discoveryTokens() string {
+	toks := make([]string, 0, 32)
+	add := func(k, v string) {
+		toks = append(toks, k+"="+api.EscapeTokenValue(v))
+	}
+	add("discovery_protocol_version", radio.DiscoveryProtocolVersion.String())
+	add("model", radio.Model)
+	add("serial", radio.Serial)
+	add("version", radio.Version.String())
+	add("nickname", radio.Nickname)
+	add("callsign", radio.Callsign)
+	add("ip", radio.IP.String())
+	add("port", strconv.Itoa(radio.Port))
+	add("status", radio.Status)
+	if radio.InUseIP != nil {
+		add("inuse_ip", radio.InUseIP.String())
+	}
+	if radio.InUseHost != "" {
+		add("inuse_host", radio.InUseHost)
+	}
+	add("max_licensed_version", radio.MaxLicensedVersion)
+	add("radio_license_id", radio.RadioLicenseID)
+	add("requires_additional_license", formatDiscoveryBool(radio.RequiresAdditionalLicense))
+	add("fpc_mac", radio.FpcMac)
+	add("wan_connected", formatDiscoveryBool(radio.WanConnected))
+	add("licensed_clients", strconv.Itoa(radio.LicensedClients))
+	add("available_clients", strconv.Itoa(radio.AvailableClients))
+	add("max_panadapters", strconv.Itoa(radio.MaxPanadapters))
+	add("available_panadapters", strconv.Itoa(radio.AvailablePanadapters))
+	add("max_slices", strconv.Itoa(radio.MaxSlices))
+	add("available_slices", strconv.Itoa(radio.AvailableSlices))
+
+	ips := make([]string, len(radio.GuiClients))
+	hosts := make([]string, len(radio.GuiClients))
+	programs := make([]string, len(radio.GuiClients))
+	stations := make([]string, len(radio.GuiClients))
+	handles := make([]string, len(radio.GuiClients))
+	for i, c := range radio.GuiClients {
+		if c.IP != nil {
+			ips[i] = c.IP.String()
+		}
+		hosts[i] = c.Host
+		programs[i] = c.Program
+		stations[i] = c.Station
+		handles[i] = fmt.Sprintf("0x%08X", c.Handle)
+	}
+	add("gui_client_ips", st.Join(ips, ","))
+	add("gui_client_hosts", st.Join(hosts, ","))
+	add("gui_client_programs", st.Join(programs, ","))
+	add("gui_client_stations", st.Join(stations, ","))
+	add("gui_client_handles", st.Join(handles, ","))
+	add("min_software_version", radio.MinSoftwareVersion.String())
+
+	extraKeys := make([]string, 0, len(radio.Extra))
+	for k := range radio.Extra {
+		extraKeys = append(extraKeys, k)
+	}
+	sort.Strings(extraKeys)
+	for _, k := range extraKeys {
+		add(k, radio.Extra[k])
+	}
+	return st.Join(toks, " ")
+}
+
+/* True if some other client is already attached to the radio */
+func (radio *Radio) InUse() bool {
+	return len(radio.GuiClients) > 0 || radio.InUseIP != nil
+}
+
+/* True if the radio can still hand out a slice */
+func (radio *Radio) HasFreeSlice() bool {
+	return radio.AvailableSlices > 0
+}
+
+/* Address of the radio's TCP API */
+func (radio *Radio) APIAddr() string {
+	port := radio.Port
+	if port == 0 {
+		port = DISCOVERY_PORT
+	}
+	return net.JoinHostPort(radio.IP.String(), strconv.Itoa(port))
+}
+
+func (radio *Radio) Equal(other *Radio) bool {
+	return reflect.DeepEqual(radio, other)
+}
+
+func (radio *Radio) String() string {
+	return fmt.Sprintf("discovery_protocol_version=%s model=%s serial=%s version=%s nickname=%s callsign=%s ip=%s port=%d status=%s clients=%d available_slices=%d",
+		radio.DiscoveryProtocolVersion.String(),
+		radio.Model,
+		radio.Serial,
+		radio.Version.String(),
+		radio.Nickname,
+		radio.Callsign,
+		radio.IP,
+		radio.Port,
+		radio.Status,
+		len(radio.GuiClients),
+		radio.AvailableSlices)
+}
@@ -0,0 +1,275 @@
+/* SPDX-License-Identifier: GPL-3.0
+ *
+ * Copyright (C) 2018 Brady OBrien. All Rights Reserved.
+ *
+ * Long-lived registry of every radio heard by a DiscoveryClient
+ */
+
+package discovery
+
+import (
+	"errors"
+	"net"
+	"sort"
+	"sync"
+	"time"
+)
+
+/* How long a radio may stay silent before it is dropped from the registry */
+const DEFAULT_RADIO_EXPIRY = 5 * time.Second
+
+const REGISTRY_EVENT_QUEUE_LEN = 32
+
+type RadioEventType int
+
+const (
+	RADIO_ADDED RadioEventType = iota
+	RADIO_CHANGED
+	RADIO_REMOVED
+)
+
+func (t RadioEventType) String() string {
+	switch t {
+	case RADIO_ADDED:
+		return "added"
+	case RADIO_CHANGED:
+		return "changed"
+	case RADIO_REMOVED:
+		return "removed"
+	}
+	return "unknown"
+}
+
+/* Change to the set of known radios */
+type RadioEvent struct {
+	Type  RadioEventType
+	Radio *Radio
+}
+
+/* Predicate used to pick radios out of the registry */
+type RadioMatcher func(*Radio) bool
+
+type registryEntry struct {
+	radio    *Radio
+	lastSeen time.Time
+}
+
+type RadioRegistry struct {
+	/* Closed once the registry has shut down */
+	Events  chan *RadioEvent
+	Errors  chan error
+	client  *DiscoveryClient
+	expiry  time.Duration
+	lock    sync.Mutex
+	radios  map[string]*registryEntry
+	changed chan int
+	quit    chan int
+	once    sync.Once /* Closes quit */
+	done    chan int
+}
+
+func MatchAny() RadioMatcher {
+	return func(*Radio) bool { return true }
+}
+
+func MatchSerial(serial string) RadioMatcher {
+	return func(r *Radio) bool { return r.Serial == serial }
+}
+
+func MatchNickname(nickname string) RadioMatcher {
+	return func(r *Radio) bool { return r.Nickname == nickname }
+}
+
+func MatchCallsign(callsign string) RadioMatcher {
+	return func(r *Radio) bool { return r.Callsign == callsign }
+}
+
+func MatchModel(model string) RadioMatcher {
+	return func(r *Radio) bool { return r.Model == model }
+}
+
+/* Combine matchers; a radio must satisfy all of them */
+func MatchAll(matchers ...RadioMatcher) RadioMatcher {
+	return func(r *Radio) bool {
+		for _, m := range matchers {
+			if !m(r) {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+/*
+ * Start a discovery client on addr and track every radio it hears.
+ * Radios not heard from within expiry are removed.
+ */
+func CreateRadioRegistry(addr *net.UDPAddr, expiry time.Duration) (*RadioRegistry, error) {
+	disClient, err := CreateDiscoveryClient(addr)
+	if err != nil {
+		return nil, err
+	}
+	if expiry <= 0 {
+		expiry = DEFAULT_RADIO_EXPIRY
+	}
+	reg := &RadioRegistry{
+		Events:  make(chan *RadioEvent, REGISTRY_EVENT_QUEUE_LEN),
+		Errors:  make(chan error, 1),
+		client:  disClient,
+		expiry:  expiry,
+		radios:  make(map[string]*registryEntry),
+		changed: make(chan int),
+		quit:    make(chan int),
+		done:    make(chan int),
+	}
+	go disClient.doDiscoveryListen()
+	go reg.registryLoop()
+	return reg, nil
+}
+
+/* Start a registry on the default discovery port */
+func StartRadioRegistry() (*RadioRegistry, error) {
+	addr, err := net.ResolveUDPAddr("udp", "0.0.0.0:4992")
+	if err != nil {
+		return nil, err
+	}
+	return CreateRadioRegistry(addr, DEFAULT_RADIO_EXPIRY)
+}
+
+/*
+ * Events are delivered without blocking the registry. If the consumer
+ * falls behind events are dropped; Radios() always reflects current state.
+ */
+func (reg *RadioRegistry) emit(evType RadioEventType, radio *Radio) {
+	select {
+	case reg.Events <- &RadioEvent{evType, radio}:
+	default:
+	}
+}
+
+/* Wake anyone waiting on a change. Must hold reg.lock */
+func (reg *RadioRegistry) notifyLocked() {
+	close(reg.changed)
+	reg.changed = make(chan int)
+}
+
+func (reg *RadioRegistry) update(radio *Radio) {
+	reg.lock.Lock()
+	defer reg.lock.Unlock()
+	entry, ok := reg.radios[radio.Serial]
+	if !ok {
+		reg.radios[radio.Serial] = &registryEntry{radio, time.Now()}
+		reg.emit(RADIO_ADDED, radio)
+		reg.notifyLocked()
+		return
+	}
+	entry.lastSeen = time.Now()
+	if !entry.radio.Equal(radio) {
+		entry.radio = radio
+		reg.emit(RADIO_CHANGED, radio)
+		reg.notifyLocked()
+	}
+}
+
+func (reg *RadioRegistry) expire() {
+	reg.lock.Lock()
+	defer reg.lock.Unlock()
+	removed := false
+	for serial, entry := range reg.radios {
+		if time.Since(entry.lastSeen) > reg.expiry {
+			delete(reg.radios, serial)
+			reg.emit(RADIO_REMOVED, entry.radio)
+			removed = true
+		}
+	}
+	if removed {
+		reg.notifyLocked()
+	}
+}
+
+func (reg *RadioRegistry) registryLoop() {
+	ticker := time.NewTicker(reg.expiry / 4)
+	defer ticker.Stop()
+	/* Nothing emits once the loop is gone, so consumers can stop ranging */
+	defer close(reg.Events)
+	defer close(reg.done)
+	for {
+		select {
+		case <-reg.quit:
+			return
+		case radio := <-reg.client.radios:
+			reg.update(radio)
+		case err := <-reg.client.errors:
+			select {
+			case reg.Errors <- err:
+			default:
+			}
+		case <-ticker.C:
+			reg.expire()
+		}
+	}
+}
+
+/* Snapshot of all known radios, sorted by serial */
+func (reg *RadioRegistry) Radios() []*Radio {
+	return reg.Find(MatchAny())
+}
+
+/* All known radios satisfying match, sorted by serial */
+func (reg *RadioRegistry) Find(match RadioMatcher) []*Radio {
+	reg.lock.Lock()
+	defer reg.lock.Unlock()
+	radios := make([]*Radio, 0, len(reg.radios))
+	for _, entry := range reg.radios {
+		if match(entry.radio) {
+			radios = append(radios, entry.radio)
+		}
+	}
+	sort.Slice(radios, func(i, j int) bool {
+		return radios[i].Serial < radios[j].Serial
+	})
+	return radios
+}
+
+func (reg *RadioRegistry) FindBySerial(serial string) *Radio {
+	reg.lock.Lock()
+	defer reg.lock.Unlock()
+	if entry, ok := reg.radios[serial]; ok {
+		return entry.radio
+	}
+	return nil
+}
+
+/*
+ * Block until at least one radio satisfies match or the timeout passes.
+ * If several radios match, the one with the lowest serial is returned so
+ * the selection is deterministic.
+ */
+func (reg *RadioRegistry) WaitForRadio(match RadioMatcher, timeout time.Duration) (*Radio, error) {
+	deadline := time.After(timeout)
+	for {
+		reg.lock.Lock()
+		changed := reg.changed
+		reg.lock.Unlock()
+
+		if radios := reg.Find(match); len(radios) > 0 {
+			return radios[0], nil
+		}
+		select {
+		case <-changed:
+		case <-deadline:
+			return nil, errors.New("WaitForRadio: no matching radio found")
+		case <-reg.quit:
+			return nil, errors.New("WaitForRadio: registry closed")
+		}
+	}
+}
+
+/* Stop the registry and its client; safe to call concurrently */
+func (reg *RadioRegistry) Close() {
+	reg.once.Do(func() {
+		close(reg.quit)
+		reg.client.Close()
+	})
+	<-reg.done
+}
@@ -5,7 +5,7 @@
  * 24Khz to 8Khz filters
  */
 
-package main
+package dsp
 
 const rs_ratio = 3
 
@@ -0,0 +1,349 @@
+/* SPDX-License-Identifier: GPL-3.0
+ *
+ * Copyright (C) 2018 Brady O'Brien. All Rights Reserved.
+ */
+
+package dsp
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/baobrien/smartsdr-golang/vita"
+)
+
+/*
+ * StVitaInputF creates a stream subscriber function. The returned function
+ * takes a VITA packet, extracts float samples, and shoves it down the
+ * channel
+ */
+func StVitaInputF(outputChan chan []float32) vita.StreamSubscriber {
+	return StVitaInputCondF(outputChan, nil)
+}
+
+/* Counts of packets whose trailers flagged trouble on a received stream */
+type StreamConditions struct {
+	OverRange   uint64
+	SampleLoss  uint64
+	InvalidData uint64
+}
+
+/* Copy the counts, safe against the stream subscriber updating them */
+func (cond *StreamConditions) Snapshot() StreamConditions {
+	return StreamConditions{
+		OverRange:   atomic.LoadUint64(&cond.OverRange),
+		SampleLoss:  atomic.LoadUint64(&cond.SampleLoss),
+		InvalidData: atomic.LoadUint64(&cond.InvalidData),
+	}
+}
+
+func (cond *StreamConditions) count(trailer *vita.VitaTrailer) {
+	if !trailer.Present {
+		return
+	}
+	if trailer.Flagged(vita.VITA_S_OVER_RANGE_I) {
+		atomic.AddUint64(&cond.OverRange, 1)
+	}
+	if trailer.Flagged(vita.VITA_S_SAMPLE_LOSS_I) {
+		atomic.AddUint64(&cond.SampleLoss, 1)
+	}
+	if on, enabled := trailer.Indicator(vita.VITA_S_VALID_DATA_I); enabled && !on {
+		atomic.AddUint64(&cond.InvalidData, 1)
+	}
+}
+
+/*
+ * Like StVitaInputF, also counting the conditions flagged in each packet's
+ * trailer into cond, which may be nil
+ */
+func StVitaInputCondF(outputChan chan []float32, cond *StreamConditions) vita.StreamSubscriber {
+	return StVitaInputFillF(outputChan, cond, GAP_FILL_NONE)
+}
+
+/* What to put in place of packets lost from a received stream */
+type GapFill int
+
+const (
+	/* Pass packets on as they come, duplicates and late ones included */
+	GAP_FILL_NONE GapFill = iota
+	/* Silence */
+	GAP_FILL_ZERO
+	/* A straight line from the last sample before the gap to the first after */
+	GAP_FILL_INTERPOLATE
+)
+
+/* Most packets' worth of samples put in for one gap */
+const MAX_GAP_FILL_PACKETS = 16
+
+/* Samples to stand in for lost packets the size of next, following last */
+func gapSamples(fill GapFill, lost int, last float32, next []float32) []float32 {
+	if lost > MAX_GAP_FILL_PACKETS {
+		lost = MAX_GAP_FILL_PACKETS
+	}
+	gap := make([]float32, lost*len(next))
+	if fill == GAP_FILL_INTERPOLATE && len(next) > 0 {
+		step := (next[0] - last) / float32(len(gap)+1)
+		for i := range gap {
+			gap[i] = last + step*float32(i+1)
+		}
+	}
+	return gap
+}
+
+/*
+ * Like StVitaInputCondF, also keeping the sample timing of the stream when
+ * packets go missing. Unless fill is GAP_FILL_NONE, duplicate and late
+ * packets are dropped, and each gap is filled with as many samples as the
+ * packets lost would have carried.
+ */
+func StVitaInputFillF(outputChan chan []float32, cond *StreamConditions, fill GapFill) vita.StreamSubscriber {
+	var last float32
+	return func(pkt *vita.VitaIFData, pool *vita.VitaBufferPool) {
+		if cond != nil {
+			cond.count(&pkt.Trailer)
+		}
+		seq := pkt.Sequence
+		samps := vita.VitaToFloat(pkt)
+		pool.ReleasePB(pkt.RawPacketBuffer, pkt)
+		if fill != GAP_FILL_NONE {
+			if seq.Event != vita.SEQ_IN_ORDER {
+				return
+			}
+			if seq.Lost > 0 {
+				outputChan <- gapSamples(fill, seq.Lost, last, samps)
+			}
+			if len(samps) > 0 {
+				last = samps[len(samps)-1]
+			}
+		}
+		outputChan <- samps
+
+	}
+}
+
+/*
+ * Creates a function meant to be run in a goroutine which waits for input
+ * buffers on InputChan, packs a frame with them, and sends it on it's way
+ * into the VitaInterface
+ */
+func StVitaOutputF(inputChan chan []float32, vif *vita.VitaInterface, headerPrototype *vita.VitaIfDataHeader) {
+	StVitaOutputClockF(inputChan, vif, headerPrototype, nil)
+}
+
+/*
+ * Time base of a received stream, for stamping the stream we send back.
+ * The input side observes received timestamps; the output side reads them.
+ */
+type StreamClock struct {
+	SampleRate float64
+
+	lock  sync.Mutex
+	ref   vita.VitaTimestamp
+	valid bool
+}
+
+func NewStreamClock(sampleRate float64) *StreamClock {
+	return &StreamClock{SampleRate: sampleRate}
+}
+
+/* Record the timestamp of a received packet */
+func (clk *StreamClock) Observe(ts vita.VitaTimestamp) {
+	if ts.IntType == vita.VITA_TSI_NONE && ts.FracType == vita.VITA_TSF_NONE {
+		return
+	}
+	clk.lock.Lock()
+	clk.ref = ts
+	clk.valid = true
+	clk.lock.Unlock()
+}
+
+/* Timestamp of the latest received packet, if any had one */
+func (clk *StreamClock) Reference() (vita.VitaTimestamp, bool) {
+	clk.lock.Lock()
+	defer clk.lock.Unlock()
+	return clk.ref, clk.valid
+}
+
+/*
+ * How far the received stream may run ahead of what we have sent before
+ * the output clock jumps forward to it
+ */
+const CLOCK_RESYNC_TOLERANCE = 250 * time.Millisecond
+
+/*
+ * Time to stamp the next output sample with, given next from counting
+ * samples sent. If the received stream has got further ahead than
+ * CLOCK_RESYNC_TOLERANCE, or changed its kind of timestamp, its latest
+ * timestamp is used instead; otherwise next is kept, so output never
+ * goes backwards or jitters with the input.
+ */
+func (clk *StreamClock) resync(next vita.VitaTimestamp) vita.VitaTimestamp {
+	ref, ok := clk.Reference()
+	if !ok {
+		return next
+	}
+	if ref.IntType != next.IntType || ref.FracType != next.FracType {
+		return ref
+	}
+	refIdx, refOk := ref.SampleIndex(clk.SampleRate)
+	nextIdx, nextOk := next.SampleIndex(clk.SampleRate)
+	if refOk && nextOk && refIdx-nextIdx > int64(clk.SampleRate*CLOCK_RESYNC_TOLERANCE.Seconds()) {
+		return ref
+	}
+	return next
+}
+
+/*
+ * Like StVitaOutputF, stamping each packet with the time of its first
+ * sample. Time starts from the latest timestamp clock has seen when the
+ * first buffer goes out, or from the prototype's if it has seen none or is
+ * nil, and advances by the samples sent. Later buffers catch up with the
+ * clock if the received stream has got well ahead, as after a stall.
+ */
+func StVitaOutputClockF(inputChan chan []float32, vif *vita.VitaInterface, headerPrototype *vita.VitaIfDataHeader, clock *StreamClock) {
+	next := vita.TimestampFromHeader(headerPrototype)
+	if next.IntType == vita.VITA_TSI_NONE && next.FracType == vita.VITA_TSF_NONE {
+		/* What PackVifSendPacket sends for untyped timestamps */
+		next.IntType = vita.VITA_TSI_OTHER
+		next.FracType = vita.VITA_TSF_SAMPLE_COUNT
+	}
+	sampleRate := 0.0
+	if clock != nil {
+		sampleRate = clock.SampleRate
+	}
+	started := false
+	for {
+		/* Nil buffer signals quit */
+		bufIn := <-inputChan
+		if bufIn == nil {
+			break
+		}
+		if clock != nil {
+			if !started {
+				if ref, ok := clock.Reference(); ok {
+					next = ref
+				}
+			} else {
+				next = clock.resync(next)
+			}
+		}
+		started = true
+		n := 0
+		for n < len(bufIn) {
+			bufSend := bufIn[n:]
+			/* Grab a packet and buffer */
+			buf, pkt := vif.BufBag.GrabPB()
+			pkt.RawPacketBuffer = buf
+			pkt.DataBytes = buf
+			/* Copy prototype header data in */
+			pkt.Header = *headerPrototype
+			next.SetHeader(&pkt.Header)
+
+			nPacked := vita.FloatToVitaFrame(pkt, bufSend)
+			next = next.AddSamples(int64(nPacked), sampleRate)
+			n += nPacked
+			vif.SendChannel <- pkt
+		}
+	}
+
+}
+
+/*
+ * Create a stream processor function which accumulates some number of samples before sending a buffer off
+ */
+func StAccumulatorF(inputChan, outputChan chan []float32, naccum int) {
+	accumulator := make([]float32, naccum)
+	nInBuf := 0
+	for {
+		bufIn := <-inputChan
+		if bufIn == nil {
+			outputChan <- nil
+			break
+		}
+		for len(bufIn) > 0 {
+			n := copy(accumulator[nInBuf:], bufIn[:])
+			nInBuf += n
+			if nInBuf == naccum {
+				outputChan <- accumulator
+				accumulator = make([]float32, naccum)
+				nInBuf = 0
+			}
+			bufIn = bufIn[n:]
+		}
+	}
+}
+
+/* */
+func SampCtrF(inputChan, outputChan chan []float32, name string, interval time.Duration) uint64 {
+	sampCount := uint64(0)
+	buf := <-inputChan
+	start := time.Now()
+	lastPrint := time.Now()
+	for {
+		if buf == nil {
+			outputChan <- nil
+			break
+		}
+		sampCount += uint64(len(buf))
+		outputChan <- buf
+
+		if time.Since(lastPrint) > interval && interval > 0 {
+			sF := float64(sampCount)
+			tF := float64(time.Since(start)) / float64(time.Second)
+			rate := sF / tF
+			fmt.Printf("%s: %v samples, %f samples/s\n", name, sampCount, rate)
+			lastPrint = time.Now()
+			sampCount = 0
+			start = lastPrint
+		}
+		buf = <-inputChan
+	}
+	return sampCount
+}
+
+func StDelatentizerF(i1, o1, i2, o2 chan []float32, maxdisp int) {
+	dispCnt := make(chan int, 10)
+
+	// Input Track Process
+	go func() {
+		for {
+			buf := <-i1
+			if buf == nil {
+				o1 <- nil
+				break
+			}
+			dispCnt <- len(buf)
+			o1 <- buf
+		}
+	}()
+
+	// Output Track Process
+	go func() {
+		rundisp := 0
+		last := time.Now()
+		for {
+			select {
+			case rsc := <-dispCnt:
+				rundisp += rsc
+				if rundisp > maxdisp {
+					fmt.Printf("Correcting RD by %d\n", rundisp)
+					o2 <- make([]float32, rundisp)
+					rundisp = 0
+				}
+			case buf := <-i2:
+				if buf == nil {
+					o2 <- nil
+					break
+				}
+				rundisp -= len(buf)
+				o2 <- buf
+			}
+			if time.Since(last) > time.Second {
+				fmt.Printf("RD is %d\n", rundisp)
+				last = time.Now()
+			}
+		}
+	}()
+}
@@ -0,0 +1,155 @@
+/* SPDX-License-Identifier: GPL-3.0
+ *
+ * Copyright (C) 2018 Brady O'Brien. All Rights Reserved.
+ */
+
+package dsp
+
+import (
+	"math"
+	"testing"
+
+	"github.com/baobrien/smartsdr-golang/vita"
+)
+
+const testSampleRate = 24000
+
+/* A UTC sample count timestamp, sample samples into second secs */
+func utcSample(secs uint32, sample uint64) vita.VitaTimestamp {
+	return vita.VitaTimestamp{IntType: vita.VITA_TSI_UTC, FracType: vita.VITA_TSF_SAMPLE_COUNT, Int: secs, Frac: sample}
+}
+
+func TestGapSamplesZero(t *testing.T) {
+	gap := gapSamples(GAP_FILL_ZERO, 2, 0.5, []float32{1, 2, 3})
+	if len(gap) != 6 {
+		t.Fatalf("got %d samples for 2 lost packets of 3, want 6", len(gap))
+	}
+	for i, s := range gap {
+		if s != 0 {
+			t.Errorf("sample %d is %v, want silence", i, s)
+		}
+	}
+}
+
+/* However long the gap, no more than MAX_GAP_FILL_PACKETS are put in */
+func TestGapSamplesCap(t *testing.T) {
+	next := make([]float32, 10)
+	for _, lost := range []int{MAX_GAP_FILL_PACKETS, MAX_GAP_FILL_PACKETS + 1, 1000} {
+		if gap := gapSamples(GAP_FILL_ZERO, lost, 0, next); len(gap) != MAX_GAP_FILL_PACKETS*len(next) {
+			t.Errorf("%d lost: got %d samples, want %d", lost, len(gap), MAX_GAP_FILL_PACKETS*len(next))
+		}
+	}
+	if gap := gapSamples(GAP_FILL_INTERPOLATE, 3, 1, nil); len(gap) != 0 {
+		t.Errorf("got %d samples for empty packets", len(gap))
+	}
+}
+
+/* Interpolation runs from the last sample up to, but not including, the next */
+func TestGapSamplesInterpolate(t *testing.T) {
+	gap := gapSamples(GAP_FILL_INTERPOLATE, 1, -1, []float32{1, 5, 5})
+	want := []float32{-0.5, 0, 0.5}
+	if len(gap) != len(want) {
+		t.Fatalf("got %d samples, want %d", len(gap), len(want))
+	}
+	for i := range want {
+		if math.Abs(float64(gap[i]-want[i])) > 1e-6 {
+			t.Errorf("sample %d is %v, want %v", i, gap[i], want[i])
+		}
+	}
+
+	/* Capped gaps still meet the next packet */
+	gap = gapSamples(GAP_FILL_INTERPOLATE, 100, 0, []float32{1})
+	if len(gap) != MAX_GAP_FILL_PACKETS {
+		t.Fatalf("got %d samples, want %d", len(gap), MAX_GAP_FILL_PACKETS)
+	}
+	step := float32(1) / float32(MAX_GAP_FILL_PACKETS+1)
+	if math.Abs(float64(gap[len(gap)-1]-step*MAX_GAP_FILL_PACKETS)) > 1e-6 {
+		t.Errorf("last sample %v, want %v", gap[len(gap)-1], step*MAX_GAP_FILL_PACKETS)
+	}
+}
+
+func TestStreamClockResync(t *testing.T) {
+	clock := NewStreamClock(testSampleRate)
+	next := utcSample(10, 1000)
+	if got := clock.resync(next); got != next {
+		t.Errorf("resynced to %+v before anything was received", got)
+	}
+
+	tolerance := uint64(testSampleRate * CLOCK_RESYNC_TOLERANCE.Seconds())
+	for _, tc := range []struct {
+		name string
+		ref  vita.VitaTimestamp
+		want vita.VitaTimestamp
+	}{
+		{"input a little ahead", utcSample(10, 1000+tolerance), next},
+		{"input well ahead", utcSample(10, 1001+tolerance), utcSample(10, 1001+tolerance)},
+		{"input seconds ahead", utcSample(12, 0), utcSample(12, 0)},
+		/* Output never goes backwards */
+		{"input behind", utcSample(9, 0), next},
+		{"input of another kind", vita.VitaTimestamp{IntType: vita.VITA_TSI_OTHER, FracType: vita.VITA_TSF_SAMPLE_COUNT, Frac: 5},
+			vita.VitaTimestamp{IntType: vita.VITA_TSI_OTHER, FracType: vita.VITA_TSF_SAMPLE_COUNT, Frac: 5}},
+	} {
+		clock.Observe(tc.ref)
+		if got := clock.resync(next); got != tc.want {
+			t.Errorf("%s: got %+v, want %+v", tc.name, got, tc.want)
+		}
+	}
+}
+
+/* Free-running real time seconds keep the clock from seeing a jump as they tick over */
+func TestStreamClockFreeRunningSeconds(t *testing.T) {
+	clock := NewStreamClock(testSampleRate)
+	freeRunning := func(secs uint32, picos uint64) vita.VitaTimestamp {
+		return vita.VitaTimestamp{IntType: vita.VITA_TSI_OTHER, FracType: vita.VITA_TSF_REAL_TIME, Int: secs, Frac: picos}
+	}
+	next := freeRunning(11, vita.VITA_PICOSECONDS_PER_SECOND/20)
+	clock.Observe(freeRunning(10, vita.VITA_PICOSECONDS_PER_SECOND*99/100))
+	if got := clock.resync(next); got != next {
+		t.Errorf("input just behind in the last second: got %+v, want %+v", got, next)
+	}
+	ahead := freeRunning(12, 0)
+	clock.Observe(ahead)
+	if got := clock.resync(next); got != ahead {
+		t.Errorf("input a second ahead: got %+v, want %+v", got, ahead)
+	}
+}
+
+/* Stamps follow the samples sent, and jump ahead only when the input does */
+func TestStVitaOutputClockF(t *testing.T) {
+	vif := &vita.VitaInterface{
+		BufBag:      vita.CreateVitaBufferPool(8),
+		SendChannel: make(chan *vita.VitaIFData, 8),
+	}
+	clock := NewStreamClock(testSampleRate)
+	input := make(chan []float32)
+	done := make(chan int)
+	go func() {
+		StVitaOutputClockF(input, vif, &vita.VitaIfDataHeader{StreamID: 0x84000000}, clock)
+		close(done)
+	}()
+	sent := func(want vita.VitaTimestamp) {
+		t.Helper()
+		pkt := <-vif.SendChannel
+		if got := vita.TimestampFromHeader(&pkt.Header); got != want {
+			t.Errorf("packet stamped %+v, want %+v", got, want)
+		}
+		vif.BufBag.ReleasePB(pkt.RawPacketBuffer, pkt)
+	}
+
+	clock.Observe(utcSample(10, 0))
+	input <- make([]float32, 100)
+	sent(utcSample(10, 0))
+	/* The input moving on a little doesn't disturb the count */
+	clock.Observe(utcSample(10, 150))
+	input <- make([]float32, 100)
+	sent(utcSample(10, 100))
+	/* After a stall the output catches up */
+	clock.Observe(utcSample(11, 0))
+	input <- make([]float32, 100)
+	sent(utcSample(11, 0))
+	input <- make([]float32, 100)
+	sent(utcSample(11, 100))
+
+	input <- nil
+	<-done
+}
@@ -0,0 +1,286 @@
+//go:build !nocodec2
+
+/* SPDX-License-Identifier: GPL-3.0
+ *
+ * Copyright (C) 2018 Brady O'Brien. All Rights Reserved.
+ */
+
+package freedv
+
+// #cgo LDFLAGS: -lcodec2
+// #include <codec2/freedv_api.h>
+// typedef struct freedv freedv_s;
+// extern void freedvTextRx(void *, char);
+// extern char freedvTextTx(void *);
+import "C"
+import (
+	"errors"
+	"runtime/cgo"
+	"sync"
+	"unsafe"
+)
+
+type Freedv struct {
+	fdv  *C.freedv_s
+	mode FreedvMode
+	text *freedvText
+	/* Handle to text, copied into C memory as callback state */
+	textHandle cgo.Handle
+	textState  unsafe.Pointer
+	/*
+	 * RX and TX stages share one modem, which may be reopened in another
+	 * mode underneath them; serializes every call into codec2
+	 */
+	lock sync.Mutex
+}
+
+func FreedvOpen(mode FreedvMode) (*Freedv, error) {
+	fdv := C.freedv_open(C.int(mode))
+	if fdv == nil {
+		return nil, errors.New("Something went wrong opening FreeDV")
+	}
+	sfdv := &Freedv{fdv: fdv, mode: mode, text: newFreedvText()}
+	sfdv.textHandle, sfdv.textState = newTextState(sfdv.text)
+	sfdv.setTextCallbacks(fdv)
+	return sfdv, nil
+}
+
+func (fdv *Freedv) setTextCallbacks(cfdv *C.freedv_s) {
+	C.freedv_set_callback_txt(cfdv, C.freedv_callback_rx(C.freedvTextRx), C.freedv_callback_tx(C.freedvTextTx), fdv.textState)
+}
+
+/*
+ * Replace the modem with a freshly opened one in mode. Stages using fdv
+ * keep running, and should notice the change through Mode(). On failure
+ * the old modem is kept. A closed modem can't be reopened.
+ */
+func (fdv *Freedv) Reopen(mode FreedvMode) error {
+	fdv.lock.Lock()
+	defer fdv.lock.Unlock()
+	/* Once closed, the text callback state is gone too */
+	if fdv.fdv == nil {
+		return ErrModemClosed
+	}
+	newFdv := C.freedv_open(C.int(mode))
+	if newFdv == nil {
+		return errors.New("Something went wrong opening FreeDV")
+	}
+	fdv.setTextCallbacks(newFdv)
+	C.freedv_close(fdv.fdv)
+	fdv.fdv = newFdv
+	fdv.mode = mode
+	return nil
+}
+
+func (fdv *Freedv) Mode() FreedvMode {
+	fdv.lock.Lock()
+	defer fdv.lock.Unlock()
+	return fdv.mode
+}
+
+/* Free the modem. Getters return zero values afterwards */
+func (fdv *Freedv) Close() error {
+	if fdv == nil {
+		return nil
+	}
+	fdv.lock.Lock()
+	defer fdv.lock.Unlock()
+	if fdv.fdv == nil {
+		return nil
+	}
+	C.freedv_close(fdv.fdv)
+	fdv.fdv = nil
+	freeTextState(fdv.textHandle, fdv.textState)
+	fdv.text.stop()
+	return nil
+}
+
+func (fdv *Freedv) Nin() int {
+	fdv.lock.Lock()
+	defer fdv.lock.Unlock()
+	if fdv.fdv == nil {
+		return 0
+	}
+	nin := C.freedv_nin(fdv.fdv)
+	return int(nin)
+}
+
+func (fdv *Freedv) GetSampleRate() int {
+	fdv.lock.Lock()
+	defer fdv.lock.Unlock()
+	if fdv.fdv == nil {
+		return 0
+	}
+	rs := C.freedv_get_modem_sample_rate(fdv.fdv)
+	return int(rs)
+}
+
+/* 8 kHz for every mode except 2020, which runs at 16 kHz */
+func (fdv *Freedv) GetSpeechSampleRate() int {
+	fdv.lock.Lock()
+	defer fdv.lock.Unlock()
+	if fdv.fdv == nil {
+		return 0
+	}
+	rs := C.freedv_get_speech_sample_rate(fdv.fdv)
+	return int(rs)
+}
+
+func (fdv *Freedv) GetMaxModemSamps() int {
+	fdv.lock.Lock()
+	defer fdv.lock.Unlock()
+	if fdv.fdv == nil {
+		return 0
+	}
+	mms := C.freedv_get_n_max_modem_samples(fdv.fdv)
+	return int(mms)
+}
+
+func (fdv *Freedv) GetNomModemSamps() int {
+	fdv.lock.Lock()
+	defer fdv.lock.Unlock()
+	if fdv.fdv == nil {
+		return 0
+	}
+	nms := C.freedv_get_n_nom_modem_samples(fdv.fdv)
+	return int(nms)
+}
+
+func (fdv *Freedv) GetNSpeechSamples() int {
+	fdv.lock.Lock()
+	defer fdv.lock.Unlock()
+	if fdv.fdv == nil {
+		return 0
+	}
+	nss := C.freedv_get_n_speech_samples(fdv.fdv)
+	return int(nss)
+}
+
+func (fdv *Freedv) GetSync() bool {
+	fdv.lock.Lock()
+	defer fdv.lock.Unlock()
+	if fdv.fdv == nil {
+		return false
+	}
+	sync := C.freedv_get_sync(fdv.fdv)
+	return int(sync) > 0
+}
+
+/*
+ * Demodulate Nin() samples. Sizes are checked against the modem as it is
+ * at the time of the call, so a frame sized for a previous mode is
+ * rejected rather than overrunning. Returns 0 once closed.
+ */
+func (fdv *Freedv) RxFloat(rxsamp []float32, rxspeech []int16) int {
+	fdv.lock.Lock()
+	defer fdv.lock.Unlock()
+	if fdv.fdv == nil {
+		return 0
+	}
+	nin := int(C.freedv_nin(fdv.fdv))
+	nss := int(C.freedv_get_n_speech_samples(fdv.fdv))
+	if len(rxsamp) < nin || nin == 0 {
+		return 0
+	}
+	if len(rxspeech) < nss || nss == 0 {
+		return 0
+	}
+	nout := C.freedv_floatrx(fdv.fdv, (*C.short)(&rxspeech[0]), (*C.float)(&rxsamp[0]))
+	return int(nout)
+}
+
+/*
+ * Modulate one frame of speech. speechIn must hold GetNSpeechSamples()
+ * samples and modOut room for GetNomModemSamps(); returns the number of
+ * modem samples written
+ */
+func (fdv *Freedv) Tx(modOut []int16, speechIn []int16) int {
+	fdv.lock.Lock()
+	defer fdv.lock.Unlock()
+	if fdv.fdv == nil {
+		return 0
+	}
+	nss := int(C.freedv_get_n_speech_samples(fdv.fdv))
+	nom := int(C.freedv_get_n_nom_modem_samples(fdv.fdv))
+	if len(speechIn) < nss || nss == 0 {
+		return 0
+	}
+	if len(modOut) < nom || nom == 0 {
+		return 0
+	}
+	C.freedv_tx(fdv.fdv, (*C.short)(&modOut[0]), (*C.short)(&speechIn[0]))
+	return nom
+}
+
+/* As Tx, but produces complex modem samples */
+func (fdv *Freedv) ComplexTx(modOut []complex64, speechIn []int16) int {
+	fdv.lock.Lock()
+	defer fdv.lock.Unlock()
+	if fdv.fdv == nil {
+		return 0
+	}
+	nss := int(C.freedv_get_n_speech_samples(fdv.fdv))
+	nom := int(C.freedv_get_n_nom_modem_samples(fdv.fdv))
+	if len(speechIn) < nss || nss == 0 {
+		return 0
+	}
+	if len(modOut) < nom || nom == 0 {
+		return 0
+	}
+	/* COMP is a pair of floats, laid out the same as complex64 */
+	C.freedv_comptx(fdv.fdv, (*C.COMP)(unsafe.Pointer(&modOut[0])), (*C.short)(&speechIn[0]))
+	return nom
+}
+
+/* All zero once closed */
+func (fdv *Freedv) FrameSizes() ModemFrameSizes {
+	fdv.lock.Lock()
+	defer fdv.lock.Unlock()
+	if fdv.fdv == nil {
+		return ModemFrameSizes{}
+	}
+	return ModemFrameSizes{
+		Nin:              int(C.freedv_nin(fdv.fdv)),
+		MaxModemSamps:    int(C.freedv_get_n_max_modem_samples(fdv.fdv)),
+		NomModemSamps:    int(C.freedv_get_n_nom_modem_samples(fdv.fdv)),
+		NSpeechSamples:   int(C.freedv_get_n_speech_samples(fdv.fdv)),
+		ModemSampleRate:  int(C.freedv_get_modem_sample_rate(fdv.fdv)),
+		SpeechSampleRate: int(C.freedv_get_speech_sample_rate(fdv.fdv)),
+	}
+}
+
+/* Sync state and SNR estimate only */
+func (fdv *Freedv) GetModemStats() (bool, float32) {
+	var sync C.int
+	var snr C.float
+	fdv.lock.Lock()
+	defer fdv.lock.Unlock()
+	if fdv.fdv == nil {
+		return false, 0
+	}
+	C.freedv_get_modem_stats(fdv.fdv, &sync, &snr)
+	return sync != 0, float32(snr)
+}
+
+func (fdv *Freedv) GetStats() *ModemStats {
+	var mstats C.struct_MODEM_STATS
+	fdv.lock.Lock()
+	if fdv.fdv == nil {
+		fdv.lock.Unlock()
+		return &ModemStats{}
+	}
+	C.freedv_get_modem_extended_stats(fdv.fdv, &mstats)
+	totalBits := C.freedv_get_total_bits(fdv.fdv)
+	totalBitErrors := C.freedv_get_total_bit_errors(fdv.fdv)
+	fdv.lock.Unlock()
+	return &ModemStats{
+		Sync:           mstats.sync != 0,
+		SNR:            float32(mstats.snr_est),
+		FreqOffset:     float32(mstats.foff),
+		ClockOffset:    float32(mstats.clock_offset),
+		SyncMetric:     float32(mstats.sync_metric),
+		RxTiming:       float32(mstats.rx_timing),
+		TotalBits:      int(totalBits),
+		TotalBitErrors: int(totalBitErrors),
+	}
+}
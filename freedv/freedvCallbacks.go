@@ -0,0 +1,47 @@
+//go:build !nocodec2
+
+/* SPDX-License-Identifier: GPL-3.0
+ *
+ * Copyright (C) 2018 Brady O'Brien. All Rights Reserved.
+ *
+ * Callbacks codec2 uses for the text side channel. C only ever holds a
+ * cgo.Handle, kept in C memory, never a Go pointer.
+ */
+
+package freedv
+
+// #include <stdint.h>
+// #include <stdlib.h>
+import "C"
+import (
+	"runtime/cgo"
+	"unsafe"
+)
+
+/* Make a handle to text and a C copy of it to pass as callback state */
+func newTextState(text *freedvText) (cgo.Handle, unsafe.Pointer) {
+	handle := cgo.NewHandle(text)
+	state := C.malloc(C.size_t(unsafe.Sizeof(C.uintptr_t(0))))
+	*(*C.uintptr_t)(state) = C.uintptr_t(handle)
+	return handle, state
+}
+
+func freeTextState(handle cgo.Handle, state unsafe.Pointer) {
+	handle.Delete()
+	C.free(state)
+}
+
+func freedvTextFromState(state unsafe.Pointer) *freedvText {
+	handle := cgo.Handle(*(*C.uintptr_t)(state))
+	return handle.Value().(*freedvText)
+}
+
+//export freedvTextRx
+func freedvTextRx(state unsafe.Pointer, c C.char) {
+	freedvTextFromState(state).rxChar(byte(c))
+}
+
+//export freedvTextTx
+func freedvTextTx(state unsafe.Pointer) C.char {
+	return C.char(freedvTextFromState(state).txChar())
+}
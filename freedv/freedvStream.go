@@ -0,0 +1,154 @@
+/* SPDX-License-Identifier: GPL-3.0
+ *
+ * Copyright (C) 2018 Brady O'Brien. All Rights Reserved.
+ */
+
+package freedv
+
+const scaleShort = float32(8000)
+
+/* Rate of the speech side of the pipeline, between the resamplers and the modem */
+const pipelineSpeechRate = 8000
+
+/*
+ * Convert modem speech to pipeline-rate floats. 2020 produces 16 kHz
+ * speech; it is brought down to 8 kHz by averaging pairs of samples.
+ */
+func speechToPipeline(speechS []int16, speechRate int) []float32 {
+	ratio := speechRate / pipelineSpeechRate
+	if ratio < 1 {
+		ratio = 1
+	}
+	speech := make([]float32, len(speechS)/ratio)
+	for i := range speech {
+		v := float32(0)
+		for j := 0; j < ratio; j++ {
+			v += float32(speechS[i*ratio+j])
+		}
+		speech[i] = v / float32(ratio) / scaleShort
+	}
+	return speech
+}
+
+/*
+ * Demodulate modem samples into 8 kHz speech. If the modem changes its
+ * framing while running, any partial frame is dropped.
+ */
+func StFreedvRxF(inputChan, outputChan chan []float32, modem Modem) {
+	sz := modem.FrameSizes()
+	accumulator := make([]float32, sz.MaxModemSamps)
+	speechS := make([]int16, sz.NSpeechSamples)
+	nin := sz.Nin
+	nInBuf := 0
+	for {
+		bufIn := <-inputChan
+		if bufIn == nil {
+			outputChan <- nil
+			break
+		}
+		for len(bufIn) > 0 && nin > 0 {
+			n := copy(accumulator[nInBuf:nin], bufIn[:])
+			nInBuf += n
+			if nInBuf == nin {
+				nout := modem.RxFloat(accumulator, speechS)
+				if nout > 0 {
+					outputChan <- speechToPipeline(speechS[:nout], sz.SpeechSampleRate)
+				}
+				cur := modem.FrameSizes()
+				if !cur.SameFraming(sz) {
+					/* Modem was reopened with other framing; start afresh */
+					sz = cur
+					accumulator = make([]float32, sz.MaxModemSamps)
+					speechS = make([]int16, sz.NSpeechSamples)
+				}
+				nin = cur.Nin
+				nInBuf = 0
+			}
+			bufIn = bufIn[n:]
+		}
+	}
+}
+
+/* Convert a float sample to a short, clipping rather than wrapping */
+func floatToShort(v float32) int16 {
+	v *= scaleShort
+	if v > 32767 {
+		return 32767
+	}
+	if v < -32768 {
+		return -32768
+	}
+	return int16(v)
+}
+
+/*
+ * Fill speechS from pipeline-rate speech, interpolating linearly up to
+ * the modem's speech rate
+ */
+func pipelineToSpeech(speechS []int16, speech []float32, speechRate int) {
+	ratio := speechRate / pipelineSpeechRate
+	if ratio < 1 {
+		ratio = 1
+	}
+	for i, v := range speech {
+		next := v
+		if i+1 < len(speech) {
+			next = speech[i+1]
+		}
+		for j := 0; j < ratio; j++ {
+			speechS[i*ratio+j] = floatToShort(v + (next-v)*float32(j)/float32(ratio))
+		}
+	}
+}
+
+/*
+ * Modulate 8 kHz speech into modem samples. Speech is collected into
+ * frames of NSpeechSamples at the modem's speech rate, and each frame
+ * produces NomModemSamps samples at the modem rate. If the modem changes
+ * its framing, any partial frame is dropped.
+ */
+func StFreedvTxF(inputChan, outputChan chan []float32, modem Modem) {
+	var sz ModemFrameSizes
+	var frame []float32
+	var speechS, modS []int16
+	nInBuf := 0
+	resize := func(cur ModemFrameSizes) {
+		sz = cur
+		ratio := sz.SpeechSampleRate / pipelineSpeechRate
+		if ratio < 1 {
+			ratio = 1
+		}
+		frame = make([]float32, sz.NSpeechSamples/ratio)
+		speechS = make([]int16, sz.NSpeechSamples)
+		modS = make([]int16, sz.NomModemSamps)
+		nInBuf = 0
+	}
+	resize(modem.FrameSizes())
+	for {
+		bufIn := <-inputChan
+		if bufIn == nil {
+			outputChan <- nil
+			break
+		}
+		if cur := modem.FrameSizes(); !cur.SameFraming(sz) {
+			resize(cur)
+		}
+		for len(bufIn) > 0 && len(frame) > 0 {
+			n := copy(frame[nInBuf:], bufIn)
+			nInBuf += n
+			if nInBuf == len(frame) {
+				pipelineToSpeech(speechS, frame, sz.SpeechSampleRate)
+				nout := modem.Tx(modS, speechS)
+				mod := make([]float32, nout)
+				for i := 0; i < nout; i++ {
+					mod[i] = float32(modS[i]) / scaleShort
+				}
+				if nout > 0 {
+					outputChan <- mod
+				}
+				nInBuf = 0
+			}
+			bufIn = bufIn[n:]
+		}
+	}
+}
@@ -0,0 +1,196 @@
+//go:build nocodec2
+
+/* SPDX-License-Identifier: GPL-3.0
+ *
+ * Copyright (C) 2018 Brady O'Brien. All Rights Reserved.
+ */
+
+package freedv
+
+import (
+	"math"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testTimeout = 2 * time.Second
+
+/* Loopback quantises samples to shorts on each side */
+const testSampleTolerance = float64(2 / scaleShort)
+
+/* Modulator feeding the demodulator, as a transmitter heard by a receiver */
+type loopbackChain struct {
+	txIn, mod, rxOut chan []float32
+	txDone, rxDone   chan int
+}
+
+func startLoopbackChain(modem Modem) *loopbackChain {
+	chain := &loopbackChain{
+		txIn:   make(chan []float32),
+		mod:    make(chan []float32, 16),
+		rxOut:  make(chan []float32, 16),
+		txDone: make(chan int),
+		rxDone: make(chan int),
+	}
+	go func() {
+		StFreedvTxF(chain.txIn, chain.mod, modem)
+		close(chain.txDone)
+	}()
+	go func() {
+		StFreedvRxF(chain.mod, chain.rxOut, modem)
+		close(chain.rxDone)
+	}()
+	return chain
+}
+
+func testSpeech(n int) []float32 {
+	speech := make([]float32, n)
+	for i := range speech {
+		speech[i] = 0.5 * float32(math.Sin(2*math.Pi*float64(i)*440/pipelineSpeechRate))
+	}
+	return speech
+}
+
+func recvBuffer(t *testing.T, ch chan []float32) []float32 {
+	t.Helper()
+	select {
+	case buf := <-ch:
+		return buf
+	case <-time.After(testTimeout):
+		t.Fatal("stage produced nothing")
+	}
+	return nil
+}
+
+/* Speech goes through the loopback modem unchanged, whatever the chunking */
+func TestLoopbackTxRx(t *testing.T) {
+	for _, mode := range []FreedvMode{FREEDV_MODE_700D, FREEDV_MODE_2020} {
+		t.Run(mode.String(), func(t *testing.T) {
+			fdv, err := FreedvOpen(mode)
+			if err != nil {
+				t.Fatalf("FreedvOpen: %v", err)
+			}
+			defer fdv.Close()
+			chain := startLoopbackChain(fdv)
+
+			const frames = 3
+			speech := testSpeech(frames * LOOPBACK_FRAME_LEN)
+			go func() {
+				/* Chunks that don't line up with frames, and a partial frame left over */
+				for i := 0; i < len(speech); i += 100 {
+					end := i + 100
+					if end > len(speech) {
+						end = len(speech)
+					}
+					chain.txIn <- speech[i:end]
+				}
+				chain.txIn <- make([]float32, LOOPBACK_FRAME_LEN/2)
+			}()
+
+			got := make([]float32, 0, len(speech))
+			for len(got) < len(speech) {
+				buf := recvBuffer(t, chain.rxOut)
+				if len(buf) != LOOPBACK_FRAME_LEN {
+					t.Errorf("got a buffer of %d samples, want %d", len(buf), LOOPBACK_FRAME_LEN)
+				}
+				got = append(got, buf...)
+			}
+			for i := range speech {
+				if math.Abs(float64(got[i]-speech[i])) > testSampleTolerance {
+					t.Fatalf("sample %d is %v, want %v", i, got[i], speech[i])
+				}
+			}
+
+			/* The partial frame stays in the modulator */
+			chain.txIn <- nil
+			if buf := recvBuffer(t, chain.rxOut); buf != nil {
+				t.Errorf("got %d samples, want the end of stream", len(buf))
+			}
+		})
+	}
+}
+
+/* A nil buffer passes down the chain and stops both stages */
+func TestLoopbackDrain(t *testing.T) {
+	fdv, _ := FreedvOpen(FREEDV_MODE_1600)
+	defer fdv.Close()
+	chain := startLoopbackChain(fdv)
+	chain.txIn <- nil
+	if buf := recvBuffer(t, chain.rxOut); buf != nil {
+		t.Fatalf("got %d samples, want the end of stream", len(buf))
+	}
+	for name, done := range map[string]chan int{"modulator": chain.txDone, "demodulator": chain.rxDone} {
+		select {
+		case <-done:
+		case <-time.After(testTimeout):
+			t.Errorf("%s still running", name)
+		}
+	}
+}
+
+/* TX text comes back as received lines, one character per modem frame */
+func TestLoopbackText(t *testing.T) {
+	fdv, _ := FreedvOpen(FREEDV_MODE_700D)
+	defer fdv.Close()
+	lines := make(chan string, 4)
+	fdv.SetTextHandler(func(line string) {
+		lines <- line
+	})
+	fdv.SetTxText("N0CALL")
+
+	modS := make([]int16, LOOPBACK_FRAME_LEN)
+	speechS := make([]int16, LOOPBACK_FRAME_LEN)
+	for pass := 0; pass < 2; pass++ {
+		for i := 0; i <= len("N0CALL"); i++ {
+			fdv.Tx(modS, speechS)
+		}
+		select {
+		case line := <-lines:
+			if line != "N0CALL" {
+				t.Errorf("pass %d: received %q", pass, line)
+			}
+		case <-time.After(testTimeout):
+			t.Fatalf("pass %d: no line received", pass)
+		}
+	}
+
+	fdv.SetTxText("")
+	for i := 0; i < 10; i++ {
+		fdv.Tx(modS, speechS)
+	}
+	select {
+	case line := <-lines:
+		t.Errorf("received %q with TX text cleared", line)
+	case <-time.After(10 * time.Millisecond):
+	}
+}
+
+/* Received characters are assembled into lines, and overlong lines forced out */
+func TestTextRx(t *testing.T) {
+	text := newFreedvText()
+	defer text.stop()
+	lines := make(chan string, 4)
+	text.lock.Lock()
+	text.handler = func(line string) {
+		lines <- line
+	}
+	text.lock.Unlock()
+	/* Control characters are dropped */
+	for _, c := range []byte("N0\x01CALL\rde ") {
+		text.rxChar(c)
+	}
+	for i := 0; i < FREEDV_TEXT_MAX_LEN; i++ {
+		text.rxChar('x')
+	}
+	for _, want := range []string{"N0CALL", "de " + strings.Repeat("x", FREEDV_TEXT_MAX_LEN-3)} {
+		select {
+		case line := <-lines:
+			if line != want {
+				t.Errorf("received %q, want %q", line, want)
+			}
+		case <-time.After(testTimeout):
+			t.Fatalf("no line for %q", want)
+		}
+	}
+}
@@ -0,0 +1,122 @@
+/* SPDX-License-Identifier: GPL-3.0
+ *
+ * Copyright (C) 2018 Brady O'Brien. All Rights Reserved.
+ *
+ * FreeDV text side channel: assembles received characters into lines and
+ * feeds characters of the TX text to the modem
+ */
+
+package freedv
+
+import "sync"
+
+/* Longest received line kept before it is forced out */
+const FREEDV_TEXT_MAX_LEN = 64
+
+/* Received lines are terminated by a carriage return */
+const FREEDV_TEXT_EOL = '\r'
+
+/* Received lines waiting for the handler */
+const FREEDV_TEXT_QUEUE_LEN = 16
+
+type FreedvTextHandler func(string)
+
+type freedvText struct {
+	lock    sync.Mutex
+	rxBuf   []byte
+	txText  []byte
+	txPos   int
+	handler FreedvTextHandler
+	/* Lines go to handler from their own goroutine, away from the modem lock */
+	lines chan string
+	quit  chan int
+}
+
+func newFreedvText() *freedvText {
+	text := &freedvText{
+		rxBuf: make([]byte, 0, FREEDV_TEXT_MAX_LEN),
+		lines: make(chan string, FREEDV_TEXT_QUEUE_LEN),
+		quit:  make(chan int),
+	}
+	go text.deliverLoop()
+	return text
+}
+
+func (text *freedvText) deliverLoop() {
+	for {
+		select {
+		case <-text.quit:
+			return
+		case line := <-text.lines:
+			text.lock.Lock()
+			handler := text.handler
+			text.lock.Unlock()
+			if handler != nil {
+				handler(line)
+			}
+		}
+	}
+}
+
+func (text *freedvText) stop() {
+	close(text.quit)
+}
+
+/* Hand a finished line to the handler. Must hold lock */
+func (text *freedvText) flushLocked() {
+	if len(text.rxBuf) == 0 {
+		return
+	}
+	line := string(text.rxBuf)
+	text.rxBuf = text.rxBuf[:0]
+	/* Drop the line rather than stall the modem if the handler is behind */
+	select {
+	case text.lines <- line:
+	default:
+	}
+}
+
+func (text *freedvText) rxChar(c byte) {
+	text.lock.Lock()
+	defer text.lock.Unlock()
+	switch {
+	case c == FREEDV_TEXT_EOL:
+		text.flushLocked()
+	case c >= ' ' && c < 0x7f:
+		text.rxBuf = append(text.rxBuf, c)
+		if len(text.rxBuf) >= FREEDV_TEXT_MAX_LEN {
+			text.flushLocked()
+		}
+	}
+}
+
+/* Next character to send, repeating the TX text with a line end after each pass */
+func (text *freedvText) txChar() byte {
+	text.lock.Lock()
+	defer text.lock.Unlock()
+	if len(text.txText) == 0 {
+		return 0
+	}
+	if text.txPos >= len(text.txText) {
+		text.txPos = 0
+		return FREEDV_TEXT_EOL
+	}
+	c := text.txText[text.txPos]
+	text.txPos++
+	return c
+}
+
+/* Call handler with each line of text received, on its own goroutine */
+func (fdv *Freedv) SetTextHandler(handler FreedvTextHandler) {
+	fdv.text.lock.Lock()
+	defer fdv.text.lock.Unlock()
+	fdv.text.handler = handler
+}
+
+/* Text to send repeatedly alongside voice, usually a callsign. Empty stops it */
+func (fdv *Freedv) SetTxText(s string) {
+	fdv.text.lock.Lock()
+	defer fdv.text.lock.Unlock()
+	fdv.text.txText = []byte(s)
+	fdv.text.txPos = 0
+}
@@ -0,0 +1,129 @@
+/* SPDX-License-Identifier: GPL-3.0
+ *
+ * Copyright (C) 2018 Brady O'Brien. All Rights Reserved.
+ *
+ * Digital voice modem interface used by the stream stages. Freedv is the
+ * implementation; building with -tags nocodec2 swaps in a loopback which
+ * needs no C libraries.
+ */
+
+package freedv
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var ErrModemClosed = errors.New("Freedv: modem has been closed")
+
+/* Frame sizes of a modem, read together so they are consistent */
+type ModemFrameSizes struct {
+	/* Modem samples wanted by the next Rx call */
+	Nin              int
+	MaxModemSamps    int
+	NomModemSamps    int
+	NSpeechSamples   int
+	ModemSampleRate  int
+	SpeechSampleRate int
+}
+
+/* Whether frames sized for sz still fit o; Nin varies frame to frame and is ignored */
+func (sz ModemFrameSizes) SameFraming(o ModemFrameSizes) bool {
+	sz.Nin, o.Nin = 0, 0
+	return sz == o
+}
+
+/* Receive statistics of a modem */
+type ModemStats struct {
+	Sync bool
+	/* SNR estimate in dB, in a 3 kHz noise bandwidth */
+	SNR float32
+	/* Frequency offset in Hz */
+	FreqOffset float32
+	/* Sample clock offset, as a fraction */
+	ClockOffset float32
+	SyncMetric  float32
+	RxTiming    float32
+	/* Counted since the modem was opened; only meaningful for test frames */
+	TotalBits      int
+	TotalBitErrors int
+}
+
+/* Bit error rate over TotalBits, or zero before any bits have been counted */
+func (stats *ModemStats) BER() float64 {
+	if stats.TotalBits == 0 {
+		return 0
+	}
+	return float64(stats.TotalBitErrors) / float64(stats.TotalBits)
+}
+
+/*
+ * A digital voice modem. Speech is 16 bit at SpeechSampleRate, modem
+ * samples are float at the modem rate. Implementations must be safe to
+ * use from an RX and a TX stage at once, and may change their framing
+ * while running; stages watch FrameSizes for that.
+ */
+type Modem interface {
+	FrameSizes() ModemFrameSizes
+	Nin() int
+	GetSampleRate() int
+	GetSpeechSampleRate() int
+	GetMaxModemSamps() int
+	GetNomModemSamps() int
+	GetNSpeechSamples() int
+	/* Demodulate Nin() samples; returns the number of speech samples written */
+	RxFloat(rxsamp []float32, rxspeech []int16) int
+	/* Modulate one frame of speech; returns the number of modem samples written */
+	Tx(modOut []int16, speechIn []int16) int
+	GetSync() bool
+	GetStats() *ModemStats
+	Close() error
+}
+
+type FreedvMode int
+
+const (
+	FREEDV_MODE_1600  FreedvMode = 0
+	FREEDV_MODE_700              = 1
+	FREEDV_MODE_700B             = 2
+	FREEDV_MODE_2400A            = 3
+	FREEDV_MODE_2400B            = 4
+	FREEDV_MODE_800XA            = 5
+	FREEDV_MODE_700C             = 6
+	FREEDV_MODE_700D             = 7
+	FREEDV_MODE_2020             = 8
+)
+
+/* Names operators use for each mode */
+var freedvModeNames = map[FreedvMode]string{
+	FREEDV_MODE_1600:  "1600",
+	FREEDV_MODE_700:   "700",
+	FREEDV_MODE_700B:  "700B",
+	FREEDV_MODE_2400A: "2400A",
+	FREEDV_MODE_2400B: "2400B",
+	FREEDV_MODE_800XA: "800XA",
+	FREEDV_MODE_700C:  "700C",
+	FREEDV_MODE_700D:  "700D",
+	FREEDV_MODE_2020:  "2020",
+}
+
+func (mode FreedvMode) String() string {
+	if name, ok := freedvModeNames[mode]; ok {
+		return name
+	}
+	return fmt.Sprintf("FreedvMode(%d)", int(mode))
+}
+
+/* Look up a mode by the name operators use for it, e.g. "700D" */
+func ParseFreedvMode(name string) (FreedvMode, error) {
+	for mode, modeName := range freedvModeNames {
+		if strings.EqualFold(name, modeName) {
+			return mode, nil
+		}
+	}
+	return 0, fmt.Errorf("ParseFreedvMode: unknown mode %q", name)
+}
+
+/* Freedv, in either build, is a Modem */
+var _ Modem = (*Freedv)(nil)
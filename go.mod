@@ -0,0 +1,8 @@
+module github.com/baobrien/smartsdr-golang
+
+go 1.17
+
+require (
+	golang.org/x/sync v0.9.0
+	golang.org/x/sys v0.9.0
+)
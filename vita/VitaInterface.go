@@ -0,0 +1,346 @@
+/* SPDX-License-Identifier: GPL-3.0
+ *
+ * Copyright (C) 2018 Brady OBrien. All Rights Reserved.
+ *
+ * This implements enough of a subset of ANSI/VITA-49 to communicate
+ * waveform streams to and from FlexRadio SmartSDR devices
+ */
+
+package vita
+
+import (
+	"context"
+	b "encoding/binary"
+	"io"
+	"net"
+	"time"
+)
+
+const MAX_PACKET_LEN = 1500
+const BUF_POOL_SIZE = 100
+
+/* Pool to keep a bunch of VITA packet buffers */
+type VitaBufferPool struct {
+	DecodeBufs  chan []byte
+	VitaPackets chan *VitaIFData
+}
+
+type StreamSubscriber func(*VitaIFData, *VitaBufferPool)
+
+/* Called with each decoded context packet for a stream */
+type ContextSubscriber func(*VitaContext)
+
+/*
+ * Subscribers and ContextSubscribers are read by VitaListenLoop without
+ * locking; only change them while the loop isn't running.
+ */
+type VitaInterface struct {
+	Conn               *net.UDPConn // UDP Connection
+	SendConn           *net.UDPConn // Sender connection
+	BufBag             *VitaBufferPool
+	Subscribers        map[uint32]StreamSubscriber
+	ContextSubscribers map[uint32]ContextSubscriber
+	SendChannel        chan *VitaIFData
+	SendCounters       map[uint32]uint64
+	/* Context packets for a stream are counted apart from its data packets */
+	ContextCounters map[uint32]uint64
+	LocalAddr       net.Addr
+	RemoteAddr      net.Addr
+
+	rxSequences streamSequences
+}
+
+func CreateVitaBufferPool(nbufs uint) *VitaBufferPool {
+	nchancap := (nbufs * 3) / 2
+	bufchan := make(chan []byte, nchancap)
+	packetchan := make(chan *VitaIFData, nchancap)
+	for i := uint(0); i < nbufs; i++ {
+		bufchan <- make([]byte, MAX_PACKET_LEN)
+		packetchan <- &VitaIFData{}
+	}
+	pool := &VitaBufferPool{
+		DecodeBufs:  bufchan,
+		VitaPackets: packetchan,
+	}
+	return pool
+}
+
+/* Take a buffer and a cleared packet from the pool */
+func (pool *VitaBufferPool) GrabPB() ([]byte, *VitaIFData) {
+	buf, pkt := <-pool.DecodeBufs, <-pool.VitaPackets
+	*pkt = VitaIFData{}
+	return buf, pkt
+}
+
+func (pool *VitaBufferPool) ReleasePB(buf []byte, pkt *VitaIFData) {
+	pool.DecodeBufs <- buf
+	pool.VitaPackets <- pkt
+}
+
+/*type StreamSubscriber struct {
+	StreamID   uint32
+	PacketRxed func(packet *VitaIFData)
+}*/
+
+func InitVitaListener(localaddr, remoteaddr *net.UDPAddr) (*VitaInterface, error) {
+	conn, err := net.ListenUDP("udp", localaddr)
+	if err != nil {
+		return nil, err
+	}
+	addr2, err := net.ResolveUDPAddr("udp", "0.0.0.0:5001")
+	sendConn, err := net.DialUDP("udp", addr2, remoteaddr)
+	if err != nil {
+		return nil, err
+	}
+	vitaIface := &VitaInterface{
+		Conn:         conn,
+		LocalAddr:    localaddr,
+		RemoteAddr:   remoteaddr,
+		BufBag:       CreateVitaBufferPool(BUF_POOL_SIZE),
+		SendChannel:  make(chan *VitaIFData, 10),
+		SendCounters: make(map[uint32]uint64),
+		SendConn:     sendConn,
+		Subscribers:  make(map[uint32]StreamSubscriber),
+
+		ContextSubscribers: make(map[uint32]ContextSubscriber),
+		ContextCounters:    make(map[uint32]uint64),
+	}
+
+	return vitaIface, nil
+}
+
+func ParseVitaDataPacket(buf []byte, packet *VitaIFData) bool {
+	correct, payloadWords, headerWords := ReadVitaHeaderStream(buf, &(packet.Header))
+	if !correct {
+		return false
+	}
+	if payloadWords < 0 {
+		return false
+	}
+	packetWords := headerWords + payloadWords
+	packet.Trailer = VitaTrailer{}
+	if packet.Header.Header&VITA_HEADER_T_MASK != 0 {
+		if len(buf) < (packetWords+1)*4 {
+			return false
+		}
+		packet.Trailer = VitaTrailer{Present: true, Word: b.BigEndian.Uint32(buf[packetWords*4:])}
+	}
+	if len(buf) < packetWords*4 {
+		return false
+	}
+	packet.DataBytes = buf[headerWords*4 : packetWords*4]
+	return true
+}
+
+/*
+ * Receive packets and hand them to stream subscribers until ctx is done.
+ * Returns nil once cancelled, or the socket error that stopped it.
+ */
+func (vif *VitaInterface) VitaListenLoop(ctx context.Context) error {
+	/* Kick the blocking read below loose once ctx is done */
+	stop := make(chan int)
+	defer close(stop)
+	go func() {
+		select {
+		case <-ctx.Done():
+			vif.Conn.SetReadDeadline(time.Now())
+		case <-stop:
+		}
+	}()
+
+	// Get a packet buffer from the buffer pool
+	buffer, pkt := vif.BufBag.GrabPB()
+	for {
+		n, _, err := vif.Conn.ReadFrom(buffer)
+		if err != nil {
+			vif.BufBag.ReleasePB(buffer, pkt)
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		/* Context never goes to the data subscribers; extension context we can't decode */
+		switch packetType(buffer[:n]) {
+		case VITA_PACKET_TYPE_CONTEXT:
+			vif.dispatchContext(buffer[:n])
+			continue
+		case VITA_PACKET_TYPE_EXT_CONTEXT:
+			continue
+		}
+		if ParseVitaDataPacket(buffer[:n], pkt) {
+			pkt.Sequence = vif.rxSequences.check(&pkt.Header)
+			if sub, ok := vif.Subscribers[pkt.Header.StreamID]; ok {
+				// Add reference to underlying packet buffer slice so we can correctly free to pool later
+				pkt.RawPacketBuffer = buffer
+
+				sub(pkt, vif.BufBag)
+				// Grab a new packet from the buffer pool.
+				// If the parse gets us a valid packet, the called thing should handle release
+				// Otherwise, we  just re-use the packet
+				buffer, pkt = vif.BufBag.GrabPB()
+			}
+		}
+	}
+}
+
+func packetType(buf []byte) uint32 {
+	if len(buf) < 4 {
+		return VITA_PACKET_TYPE_IF_DATA
+	}
+	return b.BigEndian.Uint32(buf) & VITA_HEADER_PACKET_TYPE_MASK
+}
+
+/* Decode a context packet and hand it to its stream's context subscriber */
+func (vif *VitaInterface) dispatchContext(buf []byte) {
+	if len(buf) < 8 {
+		return
+	}
+	sub, ok := vif.ContextSubscribers[b.BigEndian.Uint32(buf[4:])]
+	if !ok {
+		return
+	}
+	c := &VitaContext{}
+	if ParseVitaContextPacket(buf, c) == nil {
+		sub(c)
+	}
+}
+
+/*
+func ReadVitaHeader(rawPkt []byte, header *VitaIfDataHeader) bool {
+	if len(rawPkt) < 28 {
+		return false
+	}
+	header.Header = b.BigEndian.Uint32(rawPkt[:])
+	header.StreamID = b.BigEndian.Uint32(rawPkt[4:])
+	header.ClassIDH = b.BigEndian.Uint32(rawPkt[8:])
+	header.ClassIDL = b.BigEndian.Uint32(rawPkt[12:])
+	header.TimestampInt = b.BigEndian.Uint32(rawPkt[16:])
+	header.TimestampFracH = b.BigEndian.Uint32(rawPkt[20:])
+	header.TimestampFracL = b.BigEndian.Uint32(rawPkt[24:])
+	return true
+}*/
+
+func PackVifSendPacket(packet *VitaIFData, buffer []byte, seq uint32) int {
+
+	var payload_word_count = len(packet.DataBytes) / 4
+	var hdrWord uint32 = VITA_PACKET_TYPE_IF_DATA_WITH_STREAM_ID
+	/* Meter packets and the like go out as extension data, context as context */
+	switch packetType := packet.Header.Header & VITA_HEADER_PACKET_TYPE_MASK; packetType {
+	case VITA_PACKET_TYPE_EXT_DATA_WITH_STREAM_ID, VITA_PACKET_TYPE_CONTEXT:
+		hdrWord = packetType
+	}
+	hdrWord |= VITA_HEADER_CLASS_ID_PRESENT
+	/* Both timestamps always go out; free-running sample counts unless the packet says otherwise */
+	tsi := packet.Header.Header & VITA_HEADER_TSI_MASK
+	if tsi == VITA_TSI_NONE {
+		tsi = VITA_TSI_OTHER
+	}
+	tsf := packet.Header.Header & VITA_HEADER_TSF_MASK
+	if tsf == VITA_TSF_NONE {
+		tsf = VITA_TSF_SAMPLE_COUNT
+	}
+	hdrWord |= tsi | tsf
+	hdrWord |= (seq & 0xF) << 16
+	trailer_word_count := 0
+	/* Context packets have no trailer */
+	if packet.Trailer.Present && hdrWord&VITA_HEADER_PACKET_TYPE_MASK != VITA_PACKET_TYPE_CONTEXT {
+		hdrWord |= VITA_HEADER_TRAILER_PRESENT
+		trailer_word_count = 1
+	}
+	hdrWord |= (7 + uint32(payload_word_count+trailer_word_count))
+	packetBytes := (7 + payload_word_count + trailer_word_count) * 4
+	if len(buffer) < packetBytes {
+		return 0 //Return error?
+	}
+	/* Pack the output buffer */
+
+	b.BigEndian.PutUint32(buffer[:], hdrWord)
+	b.BigEndian.PutUint32(buffer[4:], packet.Header.StreamID)
+	b.BigEndian.PutUint32(buffer[8:], packet.Header.ClassIDH)
+	b.BigEndian.PutUint32(buffer[12:], packet.Header.ClassIDL)
+	b.BigEndian.PutUint32(buffer[16:], packet.Header.TimestampInt)
+	b.BigEndian.PutUint32(buffer[20:], packet.Header.TimestampFracH)
+	b.BigEndian.PutUint32(buffer[24:], packet.Header.TimestampFracL)
+	copy(buffer[28:], packet.DataBytes[:payload_word_count*4])
+	if trailer_word_count > 0 {
+		b.BigEndian.PutUint32(buffer[28+payload_word_count*4:], packet.Trailer.Word)
+	}
+
+	return packetBytes
+}
+
+/*
+ * Send packets queued on SendChannel. A nil packet stops the loop once
+ * everything queued ahead of it is out; cancelling ctx stops it at once.
+ */
+func (vif *VitaInterface) VitaSenderLoop(ctx context.Context) error {
+	sendBuf := make([]byte, MAX_PACKET_LEN)
+	for {
+		var pkt *VitaIFData
+		select {
+		case pkt = <-vif.SendChannel:
+		case <-ctx.Done():
+			return nil
+		}
+		if pkt == nil {
+			return nil
+		}
+		/* Increment stream counter and sequence number */
+		counters := vif.SendCounters
+		if pkt.Header.Header&VITA_HEADER_PACKET_TYPE_MASK == VITA_PACKET_TYPE_CONTEXT {
+			counters = vif.ContextCounters
+		}
+		counters[pkt.Header.StreamID]++
+		count := counters[pkt.Header.StreamID]
+
+		n := PackVifSendPacket(pkt, sendBuf, uint32(count))
+		vif.BufBag.ReleasePB(pkt.RawPacketBuffer, pkt)
+		if n > 0 {
+			m, err := vif.SendConn.Write(sendBuf[:n])
+			if err != nil {
+				return err
+			}
+			if m != n {
+				return io.ErrShortWrite
+			}
+		}
+	}
+}
+
+/*
+ * Fill pkt with a context packet for c, its fields packed into buf, ready
+ * for PackVifSendPacket. Class ID and timestamps are sent the same way as
+ * for data packets.
+ */
+func fillContextPacket(c *VitaContext, buf []byte, pkt *VitaIFData) error {
+	pkt.Header = c.Header
+	pkt.Header.Header = VITA_PACKET_TYPE_CONTEXT
+	/* Leave room for the header PackVifSendPacket puts in front */
+	n, err := PackVitaContextFields(c, buf[:MAX_PACKET_LEN-28])
+	if err != nil {
+		return err
+	}
+	pkt.RawPacketBuffer = buf
+	pkt.DataBytes = buf[:n]
+	return nil
+}
+
+/* Queue a context packet for c's stream on the sender */
+func (vif *VitaInterface) SendContext(c *VitaContext) error {
+	buf, pkt := vif.BufBag.GrabPB()
+	if err := fillContextPacket(c, buf, pkt); err != nil {
+		vif.BufBag.ReleasePB(buf, pkt)
+		return err
+	}
+	vif.SendChannel <- pkt
+	return nil
+}
+
+/* Close both stream sockets. Stop the listen and sender loops first. */
+func (vif *VitaInterface) Close() error {
+	err := vif.Conn.Close()
+	if serr := vif.SendConn.Close(); err == nil {
+		err = serr
+	}
+	return err
+}
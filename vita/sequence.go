@@ -0,0 +1,207 @@
+/* SPDX-License-Identifier: GPL-3.0
+ *
+ * Copyright (C) 2018 Brady OBrien. All Rights Reserved.
+ *
+ * Per-stream tracking of received packet order, from the packet count and
+ * timestamps in the header
+ */
+
+package vita
+
+import (
+	"sync"
+)
+
+type SequenceEvent int
+
+const (
+	/* Next packet of the stream, possibly after a gap */
+	SEQ_IN_ORDER SequenceEvent = iota
+	/* Same packet as the last one */
+	SEQ_DUPLICATE
+	/* Older than one already received */
+	SEQ_REORDERED
+)
+
+/* Where a received packet fell in its stream */
+type SequenceCheck struct {
+	Event SequenceEvent
+	/* Packets missing just before this one */
+	Lost int
+}
+
+/* Receive accounting for one stream */
+type StreamStats struct {
+	Received   uint64
+	Dropped    uint64 /* Packets never seen; late arrivals are taken back off */
+	Duplicated uint64
+	Reordered  uint64
+}
+
+/*
+ * Half the 4 bit packet count range; later counts are taken as late
+ * packets. Timestamps, where the stream has them, decide instead, so only
+ * untimed streams read a loss of more than this as reordering.
+ */
+const seqCountWindow = 8
+
+type sequenceTracker struct {
+	started  bool
+	count    uint32
+	hasTime  bool
+	tsInt    uint32
+	tsFrac   uint64
+	fracSpan uint64 /* Fractional timestamp advance per packet, once seen */
+	stats    StreamStats
+}
+
+func packetCount(header *VitaIfDataHeader) uint32 {
+	return (header.Header & VITA_HEADER_PACKET_COUNT_MASK) >> 16
+}
+
+func fracTimestamp(header *VitaIfDataHeader) uint64 {
+	return uint64(header.TimestampFracH)<<32 | uint64(header.TimestampFracL)
+}
+
+/* Compare the timestamps of header and the last in-order packet */
+func (tr *sequenceTracker) compareTime(header *VitaIfDataHeader) int {
+	if header.Header&VITA_HEADER_TSI_MASK != VITA_TSI_NONE && header.TimestampInt != tr.tsInt {
+		if header.TimestampInt < tr.tsInt {
+			return -1
+		}
+		return 1
+	}
+	frac := fracTimestamp(header)
+	switch {
+	case header.Header&VITA_HEADER_TSF_MASK == VITA_TSF_NONE || frac == tr.tsFrac:
+		return 0
+	case frac < tr.tsFrac:
+		return -1
+	}
+	return 1
+}
+
+/*
+ * Fractional timestamp advance from the last in-order packet to header,
+ * if it can be told: within one second, or across seconds for real time
+ */
+func (tr *sequenceTracker) timeSpan(header *VitaIfDataHeader) (uint64, bool) {
+	frac := fracTimestamp(header)
+	if header.TimestampInt == tr.tsInt {
+		return frac - tr.tsFrac, true
+	}
+	if header.Header&VITA_HEADER_TSF_MASK != VITA_TSF_REAL_TIME {
+		return 0, false
+	}
+	secs := uint64(header.TimestampInt - tr.tsInt)
+	return secs*VITA_PICOSECONDS_PER_SECOND + frac - tr.tsFrac, true
+}
+
+func (tr *sequenceTracker) check(header *VitaIfDataHeader) SequenceCheck {
+	count := packetCount(header)
+	timed := header.Header&(VITA_HEADER_TSI_MASK|VITA_HEADER_TSF_MASK) != 0
+	tr.stats.Received++
+	if !tr.started {
+		tr.started = true
+		tr.advance(header, count, timed)
+		return SequenceCheck{Event: SEQ_IN_ORDER}
+	}
+
+	delta := (count - tr.count) & 0xF
+	lost := int(delta) - 1
+	order := 0
+	if timed && tr.hasTime {
+		order = tr.compareTime(header)
+	}
+	switch {
+	case order < 0:
+		tr.late()
+		return SequenceCheck{Event: SEQ_REORDERED}
+	case order > 0:
+		/* Timestamps settle what the 4 bit count can't */
+		if delta == 0 {
+			lost = 15
+		}
+		if elapsed, ok := tr.timeSpan(header); ok && tr.fracSpan != 0 {
+			span := (elapsed + tr.fracSpan/2) / tr.fracSpan
+			if span > 0 {
+				lost = int(span) - 1
+			}
+		}
+	case delta == 0:
+		tr.stats.Duplicated++
+		return SequenceCheck{Event: SEQ_DUPLICATE}
+	case delta > seqCountWindow:
+		tr.late()
+		return SequenceCheck{Event: SEQ_REORDERED}
+	}
+
+	if lost < 0 {
+		lost = 0
+	}
+	tr.stats.Dropped += uint64(lost)
+	if lost == 0 && timed && tr.hasTime {
+		if elapsed, ok := tr.timeSpan(header); ok && elapsed > 0 {
+			tr.fracSpan = elapsed
+		}
+	}
+	tr.advance(header, count, timed)
+	return SequenceCheck{Event: SEQ_IN_ORDER, Lost: lost}
+}
+
+/* A packet counted as dropped turned up after all */
+func (tr *sequenceTracker) late() {
+	tr.stats.Reordered++
+	if tr.stats.Dropped > 0 {
+		tr.stats.Dropped--
+	}
+}
+
+func (tr *sequenceTracker) advance(header *VitaIfDataHeader, count uint32, timed bool) {
+	tr.count = count
+	tr.hasTime = timed
+	tr.tsInt = header.TimestampInt
+	tr.tsFrac = fracTimestamp(header)
+}
+
+/* Sequence trackers for every stream received on an interface */
+type streamSequences struct {
+	lock    sync.Mutex
+	streams map[uint32]*sequenceTracker
+}
+
+func (seqs *streamSequences) check(header *VitaIfDataHeader) SequenceCheck {
+	seqs.lock.Lock()
+	defer seqs.lock.Unlock()
+	if seqs.streams == nil {
+		seqs.streams = make(map[uint32]*sequenceTracker)
+	}
+	tr, ok := seqs.streams[header.StreamID]
+	if !ok {
+		tr = &sequenceTracker{}
+		seqs.streams[header.StreamID] = tr
+	}
+	return tr.check(header)
+}
+
+/* Receive accounting for streamID, if any of its packets have arrived */
+func (vif *VitaInterface) ReceiveStats(streamID uint32) (StreamStats, bool) {
+	vif.rxSequences.lock.Lock()
+	defer vif.rxSequences.lock.Unlock()
+	tr, ok := vif.rxSequences.streams[streamID]
+	if !ok {
+		return StreamStats{}, false
+	}
+	return tr.stats, true
+}
+
+/* Receive accounting for every stream seen, by stream ID */
+func (vif *VitaInterface) AllReceiveStats() map[uint32]StreamStats {
+	vif.rxSequences.lock.Lock()
+	defer vif.rxSequences.lock.Unlock()
+	stats := make(map[uint32]StreamStats, len(vif.rxSequences.streams))
+	for id, tr := range vif.rxSequences.streams {
+		stats[id] = tr.stats
+	}
+	return stats
+}
@@ -0,0 +1,195 @@
+/* SPDX-License-Identifier: GPL-3.0
+ *
+ * Copyright (C) 2018 Brady OBrien. All Rights Reserved.
+ */
+
+package vita
+
+import (
+	"testing"
+)
+
+/* Fractional timestamp advance per packet in the timed tests */
+const testPacketSamples = 128
+
+/* Packets a second in the real time tests */
+const testPacketsPerSecond = 100
+
+/*
+ * A received packet: its 4 bit count, and sample count timestamp if
+ * timed, or UTC real time timestamp if real
+ */
+type seqPacket struct {
+	count  uint32
+	timed  bool
+	sample uint64
+	real   bool
+	secs   uint32
+	picos  uint64
+}
+
+func (p seqPacket) header() *VitaIfDataHeader {
+	header := &VitaIfDataHeader{
+		Header:   VITA_PACKET_TYPE_IF_DATA_WITH_STREAM_ID | (p.count&0xF)<<16,
+		StreamID: testContextStream,
+	}
+	if p.timed {
+		header.Header |= VITA_TSI_OTHER | VITA_TSF_SAMPLE_COUNT
+		header.TimestampFracH = uint32(p.sample >> 32)
+		header.TimestampFracL = uint32(p.sample)
+	}
+	if p.real {
+		header.Header |= VITA_TSI_UTC | VITA_TSF_REAL_TIME
+		header.TimestampInt = p.secs
+		header.TimestampFracH = uint32(p.picos >> 32)
+		header.TimestampFracL = uint32(p.picos)
+	}
+	return header
+}
+
+/* The nth packet of a timed stream */
+func timedPacket(n int) seqPacket {
+	return seqPacket{count: uint32(n), timed: true, sample: uint64(n) * testPacketSamples}
+}
+
+/* The nth packet of a real time stamped stream */
+func realTimePacket(n int) seqPacket {
+	return seqPacket{
+		count: uint32(n),
+		real:  true,
+		secs:  uint32(1000 + n/testPacketsPerSecond),
+		picos: uint64(n%testPacketsPerSecond) * (VITA_PICOSECONDS_PER_SECOND / testPacketsPerSecond),
+	}
+}
+
+var sequenceTests = []struct {
+	name    string
+	packets []seqPacket
+	want    []SequenceCheck
+	stats   StreamStats
+}{
+	{
+		name:    "in order",
+		packets: []seqPacket{{count: 3}, {count: 4}, {count: 5}},
+		want:    []SequenceCheck{{SEQ_IN_ORDER, 0}, {SEQ_IN_ORDER, 0}, {SEQ_IN_ORDER, 0}},
+		stats:   StreamStats{Received: 3},
+	},
+	{
+		name:    "single drop",
+		packets: []seqPacket{{count: 3}, {count: 5}, {count: 6}},
+		want:    []SequenceCheck{{SEQ_IN_ORDER, 0}, {SEQ_IN_ORDER, 1}, {SEQ_IN_ORDER, 0}},
+		stats:   StreamStats{Received: 3, Dropped: 1},
+	},
+	{
+		name:    "count wraps 15 to 0",
+		packets: []seqPacket{{count: 14}, {count: 15}, {count: 0}, {count: 1}},
+		want:    []SequenceCheck{{SEQ_IN_ORDER, 0}, {SEQ_IN_ORDER, 0}, {SEQ_IN_ORDER, 0}, {SEQ_IN_ORDER, 0}},
+		stats:   StreamStats{Received: 4},
+	},
+	{
+		name:    "drop across the wrap",
+		packets: []seqPacket{{count: 14}, {count: 1}},
+		want:    []SequenceCheck{{SEQ_IN_ORDER, 0}, {SEQ_IN_ORDER, 2}},
+		stats:   StreamStats{Received: 2, Dropped: 2},
+	},
+	{
+		name:    "duplicate",
+		packets: []seqPacket{{count: 7}, {count: 7}, {count: 8}},
+		want:    []SequenceCheck{{SEQ_IN_ORDER, 0}, {SEQ_DUPLICATE, 0}, {SEQ_IN_ORDER, 0}},
+		stats:   StreamStats{Received: 3, Duplicated: 1},
+	},
+	{
+		/* The late packet was counted as dropped, and is taken back off */
+		name:    "late arrival",
+		packets: []seqPacket{{count: 1}, {count: 3}, {count: 2}, {count: 4}},
+		want:    []SequenceCheck{{SEQ_IN_ORDER, 0}, {SEQ_IN_ORDER, 1}, {SEQ_REORDERED, 0}, {SEQ_IN_ORDER, 0}},
+		stats:   StreamStats{Received: 4, Reordered: 1},
+	},
+	{
+		name:    "late arrival by timestamp",
+		packets: []seqPacket{timedPacket(1), timedPacket(3), timedPacket(2), timedPacket(4)},
+		want:    []SequenceCheck{{SEQ_IN_ORDER, 0}, {SEQ_IN_ORDER, 1}, {SEQ_REORDERED, 0}, {SEQ_IN_ORDER, 0}},
+		stats:   StreamStats{Received: 4, Reordered: 1},
+	},
+	{
+		/* The count alone would make this 3 lost, or a late packet */
+		name:    "gap of more than 16 decided by timestamp",
+		packets: []seqPacket{timedPacket(0), timedPacket(1), timedPacket(21)},
+		want:    []SequenceCheck{{SEQ_IN_ORDER, 0}, {SEQ_IN_ORDER, 0}, {SEQ_IN_ORDER, 19}},
+		stats:   StreamStats{Received: 3, Dropped: 19},
+	},
+	{
+		/* A whole count cycle lost; the count alone says duplicate */
+		name:    "gap of 16 decided by timestamp",
+		packets: []seqPacket{timedPacket(0), timedPacket(1), timedPacket(17)},
+		want:    []SequenceCheck{{SEQ_IN_ORDER, 0}, {SEQ_IN_ORDER, 0}, {SEQ_IN_ORDER, 15}},
+		stats:   StreamStats{Received: 3, Dropped: 15},
+	},
+	{
+		/* Past the count window, so the count alone says late packet */
+		name:    "gap of 9 to 15 decided by timestamp",
+		packets: []seqPacket{timedPacket(0), timedPacket(1), timedPacket(12), timedPacket(13)},
+		want:    []SequenceCheck{{SEQ_IN_ORDER, 0}, {SEQ_IN_ORDER, 0}, {SEQ_IN_ORDER, 10}, {SEQ_IN_ORDER, 0}},
+		stats:   StreamStats{Received: 4, Dropped: 10},
+	},
+	{
+		name:    "real time gap across a second",
+		packets: []seqPacket{realTimePacket(95), realTimePacket(96), realTimePacket(120), realTimePacket(121)},
+		want:    []SequenceCheck{{SEQ_IN_ORDER, 0}, {SEQ_IN_ORDER, 0}, {SEQ_IN_ORDER, 23}, {SEQ_IN_ORDER, 0}},
+		stats:   StreamStats{Received: 4, Dropped: 23},
+	},
+	{
+		name:    "real time late arrival across a second",
+		packets: []seqPacket{realTimePacket(98), realTimePacket(99), realTimePacket(110), realTimePacket(100)},
+		want:    []SequenceCheck{{SEQ_IN_ORDER, 0}, {SEQ_IN_ORDER, 0}, {SEQ_IN_ORDER, 10}, {SEQ_REORDERED, 0}},
+		stats:   StreamStats{Received: 4, Dropped: 9, Reordered: 1},
+	},
+	{
+		/* With nothing but the count, a gap past the window can't be told from a late packet */
+		name:    "untimed gap past the window",
+		packets: []seqPacket{{count: 0}, {count: 1}, {count: 12}},
+		want:    []SequenceCheck{{SEQ_IN_ORDER, 0}, {SEQ_IN_ORDER, 0}, {SEQ_REORDERED, 0}},
+		stats:   StreamStats{Received: 3, Reordered: 1},
+	},
+	{
+		name:    "timestamped duplicate",
+		packets: []seqPacket{timedPacket(0), timedPacket(1), timedPacket(1)},
+		want:    []SequenceCheck{{SEQ_IN_ORDER, 0}, {SEQ_IN_ORDER, 0}, {SEQ_DUPLICATE, 0}},
+		stats:   StreamStats{Received: 3, Duplicated: 1},
+	},
+}
+
+func TestSequenceTracker(t *testing.T) {
+	for _, tc := range sequenceTests {
+		t.Run(tc.name, func(t *testing.T) {
+			tr := &sequenceTracker{}
+			for i, pkt := range tc.packets {
+				if got := tr.check(pkt.header()); got != tc.want[i] {
+					t.Errorf("packet %d (count %d): got %+v, want %+v", i, pkt.count, got, tc.want[i])
+				}
+			}
+			if tr.stats != tc.stats {
+				t.Errorf("stats %+v, want %+v", tr.stats, tc.stats)
+			}
+		})
+	}
+}
+
+/* Each stream is tracked on its own */
+func TestStreamSequences(t *testing.T) {
+	vif := &VitaInterface{}
+	other := seqPacket{count: 9}.header()
+	other.StreamID = testContextStream + 1
+	vif.rxSequences.check(seqPacket{count: 0}.header())
+	vif.rxSequences.check(other)
+	if got := vif.rxSequences.check(seqPacket{count: 1}.header()); got != (SequenceCheck{SEQ_IN_ORDER, 0}) {
+		t.Errorf("second packet of the first stream: %+v", got)
+	}
+	all := vif.AllReceiveStats()
+	if len(all) != 2 || all[testContextStream].Received != 2 || all[other.StreamID].Received != 1 {
+		t.Errorf("receive stats %+v", all)
+	}
+	if _, ok := vif.ReceiveStats(testContextStream + 2); ok {
+		t.Error("stats for a stream never received")
+	}
+}
@@ -0,0 +1,158 @@
+/* SPDX-License-Identifier: GPL-3.0
+ *
+ * Copyright (C) 2018 Brady OBrien. All Rights Reserved.
+ *
+ * Integer and fractional VITA-49 timestamps
+ */
+
+package vita
+
+import (
+	"math"
+	"time"
+)
+
+const VITA_PICOSECONDS_PER_SECOND = 1000000000000
+
+/* Start of GPS time, and how far GPS time has run ahead of UTC since (as of 2017) */
+var GPS_EPOCH = time.Date(1980, time.January, 6, 0, 0, 0, 0, time.UTC)
+
+const GPS_LEAP_SECONDS = 18
+
+/*
+ * Timestamp of a packet. IntType is one of VITA_TSI_* and Int counts
+ * seconds; FracType is one of VITA_TSF_* and Frac counts samples for
+ * VITA_TSF_SAMPLE_COUNT, or picoseconds for VITA_TSF_REAL_TIME.
+ */
+type VitaTimestamp struct {
+	IntType  uint32
+	FracType uint32
+	Int      uint32
+	Frac     uint64
+}
+
+func TimestampFromHeader(header *VitaIfDataHeader) VitaTimestamp {
+	return VitaTimestamp{
+		IntType:  header.Header & VITA_HEADER_TSI_MASK,
+		FracType: header.Header & VITA_HEADER_TSF_MASK,
+		Int:      header.TimestampInt,
+		Frac:     uint64(header.TimestampFracH)<<32 | uint64(header.TimestampFracL),
+	}
+}
+
+/* Set the timestamp types and words of header to ts */
+func (ts VitaTimestamp) SetHeader(header *VitaIfDataHeader) {
+	header.Header &^= VITA_HEADER_TSI_MASK | VITA_HEADER_TSF_MASK
+	header.Header |= ts.IntType&VITA_HEADER_TSI_MASK | ts.FracType&VITA_HEADER_TSF_MASK
+	header.TimestampInt = ts.Int
+	header.TimestampFracH = uint32(ts.Frac >> 32)
+	header.TimestampFracL = uint32(ts.Frac)
+}
+
+/* True if Int counts seconds of a known epoch */
+func (ts VitaTimestamp) absolute() bool {
+	return ts.IntType == VITA_TSI_UTC || ts.IntType == VITA_TSI_GPS
+}
+
+/*
+ * Wall clock time of ts, for UTC and GPS timestamps. sampleRate is needed
+ * for sample count fractional parts.
+ */
+func (ts VitaTimestamp) Time(sampleRate float64) (time.Time, bool) {
+	var nanos int64
+	switch ts.FracType {
+	case VITA_TSF_NONE:
+	case VITA_TSF_REAL_TIME:
+		nanos = int64(ts.Frac / 1000)
+	case VITA_TSF_SAMPLE_COUNT:
+		if sampleRate <= 0 {
+			return time.Time{}, false
+		}
+		nanos = int64(math.Round(float64(ts.Frac) * 1e9 / sampleRate))
+	default:
+		return time.Time{}, false
+	}
+	switch ts.IntType {
+	case VITA_TSI_UTC:
+		return time.Unix(int64(ts.Int), nanos).UTC(), true
+	case VITA_TSI_GPS:
+		secs := time.Duration(int64(ts.Int)-GPS_LEAP_SECONDS) * time.Second
+		return GPS_EPOCH.Add(secs + time.Duration(nanos)), true
+	}
+	return time.Time{}, false
+}
+
+/*
+ * Timestamp of t with the given types. Sample count fractional parts
+ * count samples at sampleRate since the start of the second.
+ */
+func TimestampFromTime(t time.Time, intType, fracType uint32, sampleRate float64) VitaTimestamp {
+	ts := VitaTimestamp{IntType: intType, FracType: fracType}
+	switch intType {
+	case VITA_TSI_UTC:
+		ts.Int = uint32(t.Unix())
+	case VITA_TSI_GPS:
+		ts.Int = uint32(int64(t.Sub(GPS_EPOCH)/time.Second) + GPS_LEAP_SECONDS)
+	}
+	switch fracType {
+	case VITA_TSF_REAL_TIME:
+		ts.Frac = uint64(t.Nanosecond()) * 1000
+	case VITA_TSF_SAMPLE_COUNT:
+		ts.Frac = uint64(float64(t.Nanosecond()) * sampleRate / 1e9)
+	}
+	return ts
+}
+
+/*
+ * Index of the sample at ts in a stream at sampleRate, counting from the
+ * epoch of the integer seconds. Seconds of any kind count, so free-running
+ * seconds don't wrap the index; without them sample counts stand alone.
+ */
+func (ts VitaTimestamp) SampleIndex(sampleRate float64) (int64, bool) {
+	var secs int64
+	if ts.IntType != VITA_TSI_NONE {
+		secs = int64(ts.Int)
+	}
+	switch ts.FracType {
+	case VITA_TSF_SAMPLE_COUNT:
+		if secs == 0 {
+			return int64(ts.Frac), true
+		}
+		if sampleRate <= 0 {
+			return 0, false
+		}
+		return int64(float64(secs)*sampleRate) + int64(ts.Frac), true
+	case VITA_TSF_REAL_TIME:
+		if sampleRate <= 0 {
+			return 0, false
+		}
+		frac := math.Round(float64(ts.Frac) * sampleRate / VITA_PICOSECONDS_PER_SECOND)
+		return int64(float64(secs)*sampleRate) + int64(frac), true
+	}
+	return 0, false
+}
+
+/*
+ * Timestamp n samples at sampleRate after ts. The fractional part carries
+ * into the seconds when they are UTC or GPS, as it does for real time.
+ */
+func (ts VitaTimestamp) AddSamples(n int64, sampleRate float64) VitaTimestamp {
+	switch ts.FracType {
+	case VITA_TSF_SAMPLE_COUNT:
+		ts.Frac += uint64(n)
+		if rate := uint64(sampleRate); ts.absolute() && rate > 0 && ts.Frac >= rate {
+			ts.Int += uint32(ts.Frac / rate)
+			ts.Frac %= rate
+		}
+	case VITA_TSF_REAL_TIME:
+		if sampleRate <= 0 {
+			break
+		}
+		ts.Frac += uint64(math.Round(float64(n) * VITA_PICOSECONDS_PER_SECOND / sampleRate))
+		if ts.Frac >= VITA_PICOSECONDS_PER_SECOND {
+			ts.Int += uint32(ts.Frac / VITA_PICOSECONDS_PER_SECOND)
+			ts.Frac %= VITA_PICOSECONDS_PER_SECOND
+		}
+	}
+	return ts
+}
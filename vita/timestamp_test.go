@@ -0,0 +1,175 @@
+/* SPDX-License-Identifier: GPL-3.0
+ *
+ * Copyright (C) 2018 Brady OBrien. All Rights Reserved.
+ */
+
+package vita
+
+import (
+	"testing"
+	"time"
+)
+
+/* Half way through a second, so both fractional kinds are exact */
+var testInstant = time.Date(2018, time.March, 4, 5, 6, 7, 500000000, time.UTC)
+
+func TestTimestampFromTime(t *testing.T) {
+	tests := []struct {
+		name     string
+		intType  uint32
+		fracType uint32
+		want     VitaTimestamp
+	}{
+		{"UTC real time", VITA_TSI_UTC, VITA_TSF_REAL_TIME,
+			VitaTimestamp{VITA_TSI_UTC, VITA_TSF_REAL_TIME, uint32(testInstant.Unix()), VITA_PICOSECONDS_PER_SECOND / 2}},
+		{"UTC sample count", VITA_TSI_UTC, VITA_TSF_SAMPLE_COUNT,
+			VitaTimestamp{VITA_TSI_UTC, VITA_TSF_SAMPLE_COUNT, uint32(testInstant.Unix()), testSampleRate / 2}},
+		/* GPS seconds run GPS_LEAP_SECONDS ahead of UTC seconds since the GPS epoch */
+		{"GPS real time", VITA_TSI_GPS, VITA_TSF_REAL_TIME,
+			VitaTimestamp{VITA_TSI_GPS, VITA_TSF_REAL_TIME, uint32(testInstant.Unix()-GPS_EPOCH.Unix()) + GPS_LEAP_SECONDS,
+				VITA_PICOSECONDS_PER_SECOND / 2}},
+		{"GPS sample count", VITA_TSI_GPS, VITA_TSF_SAMPLE_COUNT,
+			VitaTimestamp{VITA_TSI_GPS, VITA_TSF_SAMPLE_COUNT, uint32(testInstant.Unix()-GPS_EPOCH.Unix()) + GPS_LEAP_SECONDS,
+				testSampleRate / 2}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			ts := TimestampFromTime(testInstant, tc.intType, tc.fracType, testSampleRate)
+			if ts != tc.want {
+				t.Errorf("got %+v, want %+v", ts, tc.want)
+			}
+			back, ok := ts.Time(testSampleRate)
+			if !ok || !back.Equal(testInstant) {
+				t.Errorf("Time gave %v %v, want %v", back, ok, testInstant)
+			}
+		})
+	}
+}
+
+/*
+ * A GPS instant known independently of this package: GPS week 1930 began
+ * at midnight on 2017-01-01 GPS time, 18 seconds before midnight UTC
+ */
+func TestTimestampGPSWeek(t *testing.T) {
+	const gpsSeconds = 1930 * 7 * 24 * 3600
+	utc := time.Date(2016, time.December, 31, 23, 59, 42, 0, time.UTC)
+	gps := VitaTimestamp{IntType: VITA_TSI_GPS, FracType: VITA_TSF_NONE, Int: gpsSeconds}
+	if got, ok := gps.Time(0); !ok || !got.Equal(utc) {
+		t.Errorf("GPS %d is %v, want %v", gpsSeconds, got, utc)
+	}
+	if ts := TimestampFromTime(utc, VITA_TSI_GPS, VITA_TSF_NONE, 0); ts.Int != gpsSeconds {
+		t.Errorf("%v is GPS %d, want %d", utc, ts.Int, gpsSeconds)
+	}
+}
+
+func TestTimestampTimeUnknown(t *testing.T) {
+	for _, ts := range []VitaTimestamp{
+		{IntType: VITA_TSI_OTHER, FracType: VITA_TSF_REAL_TIME, Int: 5},
+		{IntType: VITA_TSI_NONE, FracType: VITA_TSF_SAMPLE_COUNT, Frac: 5},
+	} {
+		if _, ok := ts.Time(testSampleRate); ok {
+			t.Errorf("%+v has a wall clock time", ts)
+		}
+	}
+	/* Sample counts mean nothing without the rate */
+	ts := VitaTimestamp{IntType: VITA_TSI_UTC, FracType: VITA_TSF_SAMPLE_COUNT, Int: 5, Frac: 5}
+	if _, ok := ts.Time(0); ok {
+		t.Error("sample count converted without a sample rate")
+	}
+}
+
+func TestTimestampHeader(t *testing.T) {
+	ts := VitaTimestamp{VITA_TSI_GPS, VITA_TSF_REAL_TIME, 1234, 0x0000012345678ABC}
+	header := &VitaIfDataHeader{Header: VITA_PACKET_TYPE_IF_DATA_WITH_STREAM_ID | VITA_TSI_OTHER | VITA_TSF_SAMPLE_COUNT}
+	ts.SetHeader(header)
+	if header.Header&VITA_HEADER_PACKET_TYPE_MASK != VITA_PACKET_TYPE_IF_DATA_WITH_STREAM_ID {
+		t.Errorf("packet type lost: header %08X", header.Header)
+	}
+	if got := TimestampFromHeader(header); got != ts {
+		t.Errorf("got %+v back from the header, want %+v", got, ts)
+	}
+}
+
+func TestSampleIndex(t *testing.T) {
+	tests := []struct {
+		name string
+		ts   VitaTimestamp
+		rate float64
+		want int64
+		ok   bool
+	}{
+		{"UTC sample count", VitaTimestamp{VITA_TSI_UTC, VITA_TSF_SAMPLE_COUNT, 10, 5}, testSampleRate, 10*testSampleRate + 5, true},
+		{"GPS real time", VitaTimestamp{VITA_TSI_GPS, VITA_TSF_REAL_TIME, 10, VITA_PICOSECONDS_PER_SECOND / 4}, testSampleRate,
+			10*testSampleRate + testSampleRate/4, true},
+		/* Free-running seconds count too, so the index doesn't wrap each second */
+		{"free-running sample count", VitaTimestamp{VITA_TSI_OTHER, VITA_TSF_SAMPLE_COUNT, 10, 5}, testSampleRate, 10*testSampleRate + 5, true},
+		{"free-running real time", VitaTimestamp{VITA_TSI_OTHER, VITA_TSF_REAL_TIME, 10, VITA_PICOSECONDS_PER_SECOND / 2}, testSampleRate,
+			10*testSampleRate + testSampleRate/2, true},
+		{"free-running seconds at zero", VitaTimestamp{VITA_TSI_OTHER, VITA_TSF_SAMPLE_COUNT, 0, 5}, 0, 5, true},
+		{"free-running sample count without rate", VitaTimestamp{VITA_TSI_OTHER, VITA_TSF_SAMPLE_COUNT, 10, 5}, 0, 0, false},
+		{"sample count alone", VitaTimestamp{VITA_TSI_NONE, VITA_TSF_SAMPLE_COUNT, 0, 5}, 0, 5, true},
+		{"UTC sample count without rate", VitaTimestamp{VITA_TSI_UTC, VITA_TSF_SAMPLE_COUNT, 10, 5}, 0, 0, false},
+		{"real time without rate", VitaTimestamp{VITA_TSI_UTC, VITA_TSF_REAL_TIME, 10, 5}, 0, 0, false},
+		{"no fractional part", VitaTimestamp{VITA_TSI_UTC, VITA_TSF_NONE, 10, 0}, testSampleRate, 0, false},
+	}
+	for _, tc := range tests {
+		if got, ok := tc.ts.SampleIndex(tc.rate); got != tc.want || ok != tc.ok {
+			t.Errorf("%s: got %d %v, want %d %v", tc.name, got, ok, tc.want, tc.ok)
+		}
+	}
+}
+
+func TestAddSamples(t *testing.T) {
+	/* Picoseconds in one sample at the test rate, rounded as AddSamples does */
+	const samplePicos = (VITA_PICOSECONDS_PER_SECOND + testSampleRate/2) / testSampleRate
+	tests := []struct {
+		name string
+		ts   VitaTimestamp
+		n    int64
+		want VitaTimestamp
+	}{
+		{"UTC sample count within the second",
+			VitaTimestamp{VITA_TSI_UTC, VITA_TSF_SAMPLE_COUNT, 10, 100}, 200,
+			VitaTimestamp{VITA_TSI_UTC, VITA_TSF_SAMPLE_COUNT, 10, 300}},
+		{"UTC sample count reaching the rate",
+			VitaTimestamp{VITA_TSI_UTC, VITA_TSF_SAMPLE_COUNT, 10, testSampleRate - 1}, 1,
+			VitaTimestamp{VITA_TSI_UTC, VITA_TSF_SAMPLE_COUNT, 11, 0}},
+		{"GPS sample count carrying two seconds",
+			VitaTimestamp{VITA_TSI_GPS, VITA_TSF_SAMPLE_COUNT, 10, testSampleRate - 1}, testSampleRate + 2,
+			VitaTimestamp{VITA_TSI_GPS, VITA_TSF_SAMPLE_COUNT, 12, 1}},
+		/* Free-running counts don't roll over into seconds */
+		{"free-running sample count",
+			VitaTimestamp{VITA_TSI_OTHER, VITA_TSF_SAMPLE_COUNT, 10, testSampleRate - 1}, 1,
+			VitaTimestamp{VITA_TSI_OTHER, VITA_TSF_SAMPLE_COUNT, 10, testSampleRate}},
+		{"real time within the second",
+			VitaTimestamp{VITA_TSI_UTC, VITA_TSF_REAL_TIME, 10, 0}, testSampleRate / 2,
+			VitaTimestamp{VITA_TSI_UTC, VITA_TSF_REAL_TIME, 10, VITA_PICOSECONDS_PER_SECOND / 2}},
+		{"real time reaching the second",
+			VitaTimestamp{VITA_TSI_UTC, VITA_TSF_REAL_TIME, 10, VITA_PICOSECONDS_PER_SECOND - samplePicos}, 1,
+			VitaTimestamp{VITA_TSI_UTC, VITA_TSF_REAL_TIME, 11, 0}},
+		{"real time carrying two seconds",
+			VitaTimestamp{VITA_TSI_GPS, VITA_TSF_REAL_TIME, 10, VITA_PICOSECONDS_PER_SECOND / 2}, testSampleRate * 3 / 2,
+			VitaTimestamp{VITA_TSI_GPS, VITA_TSF_REAL_TIME, 12, 0}},
+		/* Also carries for free-running seconds, which still count seconds */
+		{"free-running real time",
+			VitaTimestamp{VITA_TSI_OTHER, VITA_TSF_REAL_TIME, 10, VITA_PICOSECONDS_PER_SECOND - samplePicos}, 1,
+			VitaTimestamp{VITA_TSI_OTHER, VITA_TSF_REAL_TIME, 11, 0}},
+	}
+	for _, tc := range tests {
+		if got := tc.ts.AddSamples(tc.n, testSampleRate); got != tc.want {
+			t.Errorf("%s: got %+v, want %+v", tc.name, got, tc.want)
+		}
+	}
+	/* The sample index moves by the samples added, whether or not they carry into seconds */
+	for _, ts := range []VitaTimestamp{
+		{VITA_TSI_UTC, VITA_TSF_SAMPLE_COUNT, 10, testSampleRate - 3},
+		{VITA_TSI_OTHER, VITA_TSF_SAMPLE_COUNT, 10, testSampleRate - 3},
+		{VITA_TSI_OTHER, VITA_TSF_REAL_TIME, 10, VITA_PICOSECONDS_PER_SECOND - samplePicos},
+	} {
+		before, _ := ts.SampleIndex(testSampleRate)
+		after, _ := ts.AddSamples(1000, testSampleRate).SampleIndex(testSampleRate)
+		if after-before != 1000 {
+			t.Errorf("%+v: sample index moved %d for 1000 samples", ts, after-before)
+		}
+	}
+}
@@ -5,7 +5,7 @@
  * Definitions and decoders required to implement a subset of the VITA 49 protocol
  */
 
-package main
+package vita
 
 import (
 	b "encoding/binary"
@@ -26,11 +26,66 @@ type VitaIfDataHeader struct {
 /* Vita packet with data */
 type VitaIFData struct {
 	Header          VitaIfDataHeader
+	Trailer         VitaTrailer
+	Sequence        SequenceCheck /* Set on receive by VitaListenLoop */
 	BytesValid      int
 	DataBytes       []byte
 	RawPacketBuffer []byte
 }
 
+/*
+ * Trailer word of a data packet. Each indicator has an enable bit saying
+ * whether it means anything; both use the VITA_S_* bit positions.
+ */
+type VitaTrailer struct {
+	Present bool
+	Word    uint32
+}
+
+/* Enable bits sit 12 above their indicator bits */
+const vitaTrailerEnableShift = 12
+
+/* Trailer associated context packet count */
+const VITA_TRAILER_CONTEXT_COUNT_E uint32 = 0x00000080
+const VITA_TRAILER_CONTEXT_COUNT_MASK uint32 = 0x0000007F
+
+/* Get an indicator (a VITA_S_*_I bit) and whether it is enabled */
+func (t *VitaTrailer) Indicator(indicator uint32) (bool, bool) {
+	enabled := t.Present && t.Word&(indicator<<vitaTrailerEnableShift) != 0
+	return t.Word&indicator != 0, enabled
+}
+
+/* True if the indicator is enabled and set */
+func (t *VitaTrailer) Flagged(indicator uint32) bool {
+	on, enabled := t.Indicator(indicator)
+	return on && enabled
+}
+
+/* Enable an indicator (a VITA_S_*_I bit) and set it to on */
+func (t *VitaTrailer) SetIndicator(indicator uint32, on bool) {
+	t.Present = true
+	t.Word |= indicator << vitaTrailerEnableShift
+	if on {
+		t.Word |= indicator
+	} else {
+		t.Word &^= indicator
+	}
+}
+
+/* Number of context packets associated with this one, if given */
+func (t *VitaTrailer) ContextCount() (int, bool) {
+	if !t.Present || t.Word&VITA_TRAILER_CONTEXT_COUNT_E == 0 {
+		return 0, false
+	}
+	return int(t.Word & VITA_TRAILER_CONTEXT_COUNT_MASK), true
+}
+
+func (t *VitaTrailer) SetContextCount(n int) {
+	t.Present = true
+	t.Word &^= VITA_TRAILER_CONTEXT_COUNT_MASK
+	t.Word |= VITA_TRAILER_CONTEXT_COUNT_E | uint32(n)&VITA_TRAILER_CONTEXT_COUNT_MASK
+}
+
 func ReadVitaHeader(rawPkt []byte, header *VitaIfDataHeader) bool {
 	if len(rawPkt) < 28 {
 		return false
@@ -56,11 +111,16 @@ func ReadVitaHeaderStream(rawPkt []byte, header *VitaIfDataHeader) (bool, int, i
 	trailerWords := 0
 	hasSID, hasCID, hasTSI, hasTSF := false, false, false, false
 
+	isData := true
 	switch headerWord & VITA_HEADER_PACKET_TYPE_MASK {
-	case VITA_PACKET_TYPE_EXT_DATA_WITH_STREAM_ID:
-	case VITA_PACKET_TYPE_IF_DATA_WITH_STREAM_ID:
+	case VITA_PACKET_TYPE_EXT_DATA_WITH_STREAM_ID, VITA_PACKET_TYPE_IF_DATA_WITH_STREAM_ID:
+		headerWords += 1
+		hasSID = true
+	case VITA_PACKET_TYPE_CONTEXT, VITA_PACKET_TYPE_EXT_CONTEXT:
+		/* Context packets always carry a stream ID, and never a trailer */
 		headerWords += 1
 		hasSID = true
+		isData = false
 	case VITA_PACKET_TYPE_EXT_DATA:
 	case VITA_PACKET_TYPE_IF_DATA:
 		break
@@ -71,7 +131,7 @@ func ReadVitaHeaderStream(rawPkt []byte, header *VitaIfDataHeader) (bool, int, i
 		hasCID = true
 	}
 
-	if headerWord&VITA_HEADER_T_MASK > 0 {
+	if isData && headerWord&VITA_HEADER_T_MASK > 0 {
 		trailerWords += 1
 	}
 
@@ -0,0 +1,504 @@
+/* SPDX-License-Identifier: GPL-3.0
+ *
+ * Copyright (C) 2018 Brady OBrien. All Rights Reserved.
+ *
+ * Encoder and decoder for VITA-49 context packets
+ */
+
+package vita
+
+import (
+	b "encoding/binary"
+	"errors"
+	"math"
+)
+
+/* Context indicator field bits, taken from vita49_context.h */
+const VITA_C_CONTEXT_FIELD_CHG_INDICATOR uint32 = 0x80000000
+const VITA_C_REFERENCE_POINT_IDENTIFIER uint32 = 0x40000000
+const VITA_C_BANDWIDTH uint32 = 0x20000000
+const VITA_C_IF_REFERENCE_FREQ uint32 = 0x10000000
+const VITA_C_RF_REFERENCE_FREQ uint32 = 0x08000000
+const VITA_C_RF_REFERENCE_FREQ_OFFSET uint32 = 0x04000000
+const VITA_C_IF_BAND_OFFSET uint32 = 0x02000000
+const VITA_C_REFERENCE_LEVEL uint32 = 0x01000000
+const VITA_C_GAIN uint32 = 0x00800000
+const VITA_C_OVER_RANGE_COUNT uint32 = 0x00400000
+const VITA_C_SAMPLE_RATE uint32 = 0x00200000
+const VITA_C_TIMESTAMP_ADJUSTMENT uint32 = 0x00100000
+const VITA_C_TIMESTAMP_CALIBRATION_TIME uint32 = 0x00080000
+const VITA_C_TEMPERATURE uint32 = 0x00040000
+const VITA_C_DEVICE_IDENTIFIER uint32 = 0x00020000
+const VITA_C_STATE_AND_EVENT_INDICATORS uint32 = 0x00010000
+const VITA_C_DATA_PACKET_PAYLOAD_FORMAT uint32 = 0x00008000
+const VITA_C_FORMATTED_GPS_GEOLOCATION uint32 = 0x00004000
+const VITA_C_FORMATTED_INS_LOCATION uint32 = 0x00002000
+const VITA_C_ECEF_EPHEMERIS uint32 = 0x00001000
+const VITA_C_RELATIVE_EPHEMERIS uint32 = 0x00000800
+const VITA_C_EPHEMERIS_REFERENCE_IND uint32 = 0x00000400
+const VITA_C_GPS_ASCII uint32 = 0x00000200
+const VITA_C_CONTEXT_ASSOCIATION_LISTS uint32 = 0x00000100
+const VITA_C_RESERVED uint32 = 0x000000FF
+
+/* State and event indicator bits, taken from vita49_context.h */
+const VITA_S_CALIBRATED_TIME_E uint32 = 0x80000000
+const VITA_S_VALID_DATA_E uint32 = 0x40000000
+const VITA_S_REFERENCE_LOCK_E uint32 = 0x20000000
+const VITA_S_AGC_INDICATOR_E uint32 = 0x10000000
+const VITA_S_DETECTED_SIGNAL_E uint32 = 0x08000000
+const VITA_S_SPECTRAL_INVERSION_E uint32 = 0x04000000
+const VITA_S_OVER_RANGE_E uint32 = 0x02000000
+const VITA_S_SAMPLE_LOSS_E uint32 = 0x01000000
+const VITA_S_CALIBRATED_TIME_I uint32 = 0x00080000
+const VITA_S_VALID_DATA_I uint32 = 0x00040000
+const VITA_S_REFERENCE_LOCK_I uint32 = 0x00020000
+const VITA_S_AGC_INDICATOR_I uint32 = 0x00010000
+const VITA_S_DETECTED_SIGNAL_I uint32 = 0x00008000
+const VITA_S_SPECTRAL_INVERSION_I uint32 = 0x00004000
+const VITA_S_OVER_RANGE_I uint32 = 0x00002000
+const VITA_S_SAMPLE_LOSS_I uint32 = 0x00001000
+
+/* Payload format real/complex types */
+const VITA_P_REAL uint32 = 0
+const VITA_P_COMPLEX_CARTESIAN uint32 = 1
+const VITA_P_COMPLEX_POLAR uint32 = 2
+
+/* Payload format data item formats */
+const VITA_P_SIGNED_FIXED uint32 = 0x00
+const VITA_P_IEEE_754_SINGLE uint32 = 0x0E
+const VITA_P_IEEE_754_DOUBLE uint32 = 0x0F
+const VITA_P_UNSIGNED_FIXED uint32 = 0x10
+
+/* Radix points of the fixed-point context fields */
+const vitaFreqRadix = 20
+const vitaLevelRadix = 7
+const vitaTempRadix = 6
+
+/* Sizes of the fixed-length geolocation and ephemeris fields, in words */
+const VITA_GEOLOCATION_WORDS = 11
+const VITA_EPHEMERIS_WORDS = 13
+
+var ErrNotContextPacket = errors.New("Not a VITA-49 context packet")
+var ErrShortContextPacket = errors.New("VITA-49 context packet is truncated")
+var ErrReservedContextField = errors.New("VITA-49 context packet uses reserved indicator bits")
+var ErrBadContextField = errors.New("Malformed variable-length VITA-49 context field")
+
+/* Format of the data packets a context packet describes */
+type VitaPayloadFormat struct {
+	LinkEfficient  bool   /* Packing method; processing-efficient if false */
+	RealComplex    uint32 /* VITA_P_REAL, VITA_P_COMPLEX_CARTESIAN or VITA_P_COMPLEX_POLAR */
+	DataItemFormat uint32 /* VITA_P_SIGNED_FIXED, VITA_P_IEEE_754_SINGLE, ... */
+	SampleRepeat   bool
+	EventTagSize   uint32
+	ChannelTagSize uint32
+	/* Sizes in bits and counts below are at least 1; zero is sent as 1 */
+	FieldSize   uint32 /* Item packing field size */
+	ItemSize    uint32 /* Data item size */
+	RepeatCount uint32
+	VectorSize  uint32
+}
+
+/*
+ * Decoded VITA-49 context packet. Indicator says which of the fields are
+ * present; only those are decoded, and only those are encoded.
+ */
+type VitaContext struct {
+	Header    VitaIfDataHeader
+	Indicator uint32
+
+	ReferencePoint        uint32
+	Bandwidth             float64 /* Hz */
+	IFReferenceFreq       float64 /* Hz */
+	RFReferenceFreq       float64 /* Hz */
+	RFReferenceFreqOffset float64 /* Hz */
+	IFBandOffset          float64 /* Hz */
+	ReferenceLevel        float64 /* dBm */
+	Gain1                 float64 /* Stage 1 gain, dB */
+	Gain2                 float64 /* Stage 2 gain, dB */
+	OverRangeCount        uint32
+	SampleRate            float64 /* Hz */
+	TimestampAdjustment   int64   /* Femtoseconds */
+	TimestampCalibration  uint32  /* Integer seconds */
+	Temperature           float64 /* Degrees C */
+	DeviceOUI             uint32
+	DeviceCode            uint16
+	StateEvent            uint32 /* VITA_S_* bits, user-defined bits in 7..0 */
+	PayloadFormat         VitaPayloadFormat
+	/* These are kept as raw words */
+	GPSGeolocation     [VITA_GEOLOCATION_WORDS]uint32
+	INSLocation        [VITA_GEOLOCATION_WORDS]uint32
+	ECEFEphemeris      [VITA_EPHEMERIS_WORDS]uint32
+	RelativeEphemeris  [VITA_EPHEMERIS_WORDS]uint32
+	EphemerisReference uint32
+	/* Starting with the OUI and the count of words that follow it */
+	GPSASCII []uint32
+	/* Starting with the two list-size words */
+	AssociationLists []uint32
+}
+
+func (c *VitaContext) Has(field uint32) bool {
+	return c.Indicator&field != 0
+}
+
+func fixedToFloat(v int64, radix uint) float64 {
+	return float64(v) / float64(uint64(1)<<radix)
+}
+
+func floatToFixed(v float64, radix uint) int64 {
+	return int64(math.Round(v * float64(uint64(1)<<radix)))
+}
+
+func minusOne(v uint32) uint32 {
+	if v == 0 {
+		return 0
+	}
+	return v - 1
+}
+
+func (pf *VitaPayloadFormat) unpack(w1, w2 uint32) {
+	pf.LinkEfficient = w1&0x80000000 != 0
+	pf.RealComplex = (w1 >> 29) & 0x3
+	pf.DataItemFormat = (w1 >> 24) & 0x1F
+	pf.SampleRepeat = w1&0x00800000 != 0
+	pf.EventTagSize = (w1 >> 20) & 0x7
+	pf.ChannelTagSize = (w1 >> 16) & 0xF
+	pf.FieldSize = ((w1 >> 6) & 0x3F) + 1
+	pf.ItemSize = (w1 & 0x3F) + 1
+	pf.RepeatCount = (w2 >> 16) + 1
+	pf.VectorSize = (w2 & 0xFFFF) + 1
+}
+
+func (pf *VitaPayloadFormat) pack() (uint32, uint32) {
+	var w1 uint32
+	if pf.LinkEfficient {
+		w1 |= 0x80000000
+	}
+	w1 |= (pf.RealComplex & 0x3) << 29
+	w1 |= (pf.DataItemFormat & 0x1F) << 24
+	if pf.SampleRepeat {
+		w1 |= 0x00800000
+	}
+	w1 |= (pf.EventTagSize & 0x7) << 20
+	w1 |= (pf.ChannelTagSize & 0xF) << 16
+	w1 |= (minusOne(pf.FieldSize) & 0x3F) << 6
+	w1 |= minusOne(pf.ItemSize) & 0x3F
+	w2 := (minusOne(pf.RepeatCount)&0xFFFF)<<16 | minusOne(pf.VectorSize)&0xFFFF
+	return w1, w2
+}
+
+/* Number of words in a context association lists field, from its first two words */
+func associationListsLen(w1, w2 uint32) int {
+	n := 2 + int((w1>>16)&0x1FF) + int(w1&0x1FF) + int(w2>>16)
+	async := int(w2 & 0x7FFF)
+	if w2&0x8000 != 0 {
+		/* Tag list as long as the asynchronous channel list */
+		async *= 2
+	}
+	return n + async
+}
+
+/* Walks big-endian words of a packet, noting when it runs off the end */
+type wordReader struct {
+	buf   []byte
+	short bool
+}
+
+func (r *wordReader) word() uint32 {
+	if len(r.buf) < 4 {
+		r.short = true
+		return 0
+	}
+	w := b.BigEndian.Uint32(r.buf)
+	r.buf = r.buf[4:]
+	return w
+}
+
+func (r *wordReader) dword() uint64 {
+	hi := uint64(r.word())
+	return hi<<32 | uint64(r.word())
+}
+
+func (r *wordReader) words(dst []uint32) {
+	for i := range dst {
+		dst[i] = r.word()
+	}
+}
+
+/*
+ * Decode a context packet. Fields follow the indicator word in the order
+ * of its bits, most significant first.
+ */
+func ParseVitaContextPacket(buf []byte, c *VitaContext) error {
+	correct, payloadWords, headerWords := ReadVitaHeaderStream(buf, &c.Header)
+	if !correct {
+		return ErrShortContextPacket
+	}
+	if c.Header.Header&VITA_HEADER_PACKET_TYPE_MASK != VITA_PACKET_TYPE_CONTEXT {
+		return ErrNotContextPacket
+	}
+	if payloadWords < 1 || len(buf) < (headerWords+payloadWords)*4 {
+		return ErrShortContextPacket
+	}
+	return c.parseFields(buf[headerWords*4 : (headerWords+payloadWords)*4])
+}
+
+func (c *VitaContext) parseFields(body []byte) error {
+	r := &wordReader{buf: body}
+	hdr := c.Header
+	*c = VitaContext{Header: hdr}
+	c.Indicator = r.word()
+	if c.Indicator&VITA_C_RESERVED != 0 {
+		return ErrReservedContextField
+	}
+
+	if c.Has(VITA_C_REFERENCE_POINT_IDENTIFIER) {
+		c.ReferencePoint = r.word()
+	}
+	if c.Has(VITA_C_BANDWIDTH) {
+		c.Bandwidth = fixedToFloat(int64(r.dword()), vitaFreqRadix)
+	}
+	if c.Has(VITA_C_IF_REFERENCE_FREQ) {
+		c.IFReferenceFreq = fixedToFloat(int64(r.dword()), vitaFreqRadix)
+	}
+	if c.Has(VITA_C_RF_REFERENCE_FREQ) {
+		c.RFReferenceFreq = fixedToFloat(int64(r.dword()), vitaFreqRadix)
+	}
+	if c.Has(VITA_C_RF_REFERENCE_FREQ_OFFSET) {
+		c.RFReferenceFreqOffset = fixedToFloat(int64(r.dword()), vitaFreqRadix)
+	}
+	if c.Has(VITA_C_IF_BAND_OFFSET) {
+		c.IFBandOffset = fixedToFloat(int64(r.dword()), vitaFreqRadix)
+	}
+	if c.Has(VITA_C_REFERENCE_LEVEL) {
+		c.ReferenceLevel = fixedToFloat(int64(int16(r.word())), vitaLevelRadix)
+	}
+	if c.Has(VITA_C_GAIN) {
+		w := r.word()
+		c.Gain2 = fixedToFloat(int64(int16(w>>16)), vitaLevelRadix)
+		c.Gain1 = fixedToFloat(int64(int16(w)), vitaLevelRadix)
+	}
+	if c.Has(VITA_C_OVER_RANGE_COUNT) {
+		c.OverRangeCount = r.word()
+	}
+	if c.Has(VITA_C_SAMPLE_RATE) {
+		c.SampleRate = fixedToFloat(int64(r.dword()), vitaFreqRadix)
+	}
+	if c.Has(VITA_C_TIMESTAMP_ADJUSTMENT) {
+		c.TimestampAdjustment = int64(r.dword())
+	}
+	if c.Has(VITA_C_TIMESTAMP_CALIBRATION_TIME) {
+		c.TimestampCalibration = r.word()
+	}
+	if c.Has(VITA_C_TEMPERATURE) {
+		c.Temperature = fixedToFloat(int64(int16(r.word())), vitaTempRadix)
+	}
+	if c.Has(VITA_C_DEVICE_IDENTIFIER) {
+		c.DeviceOUI = r.word() & VITA_CLASS_ID_OUI_MASK
+		c.DeviceCode = uint16(r.word())
+	}
+	if c.Has(VITA_C_STATE_AND_EVENT_INDICATORS) {
+		c.StateEvent = r.word()
+	}
+	if c.Has(VITA_C_DATA_PACKET_PAYLOAD_FORMAT) {
+		w1 := r.word()
+		c.PayloadFormat.unpack(w1, r.word())
+	}
+	if c.Has(VITA_C_FORMATTED_GPS_GEOLOCATION) {
+		r.words(c.GPSGeolocation[:])
+	}
+	if c.Has(VITA_C_FORMATTED_INS_LOCATION) {
+		r.words(c.INSLocation[:])
+	}
+	if c.Has(VITA_C_ECEF_EPHEMERIS) {
+		r.words(c.ECEFEphemeris[:])
+	}
+	if c.Has(VITA_C_RELATIVE_EPHEMERIS) {
+		r.words(c.RelativeEphemeris[:])
+	}
+	if c.Has(VITA_C_EPHEMERIS_REFERENCE_IND) {
+		c.EphemerisReference = r.word()
+	}
+	if c.Has(VITA_C_GPS_ASCII) {
+		oui := r.word()
+		n := r.word()
+		if r.short || int(n) > len(r.buf)/4 {
+			return ErrShortContextPacket
+		}
+		c.GPSASCII = make([]uint32, 2+n)
+		c.GPSASCII[0], c.GPSASCII[1] = oui, n
+		r.words(c.GPSASCII[2:])
+	}
+	if c.Has(VITA_C_CONTEXT_ASSOCIATION_LISTS) {
+		w1 := r.word()
+		w2 := r.word()
+		n := associationListsLen(w1, w2)
+		if r.short || n-2 > len(r.buf)/4 {
+			return ErrShortContextPacket
+		}
+		c.AssociationLists = make([]uint32, n)
+		c.AssociationLists[0], c.AssociationLists[1] = w1, w2
+		r.words(c.AssociationLists[2:])
+	}
+	if r.short {
+		return ErrShortContextPacket
+	}
+	return nil
+}
+
+/* Number of words the indicated fields take up, including the indicator */
+func (c *VitaContext) fieldWords() (int, error) {
+	if c.Indicator&VITA_C_RESERVED != 0 {
+		return 0, ErrReservedContextField
+	}
+	widths := []struct {
+		field uint32
+		words int
+	}{
+		{VITA_C_REFERENCE_POINT_IDENTIFIER, 1},
+		{VITA_C_BANDWIDTH, 2},
+		{VITA_C_IF_REFERENCE_FREQ, 2},
+		{VITA_C_RF_REFERENCE_FREQ, 2},
+		{VITA_C_RF_REFERENCE_FREQ_OFFSET, 2},
+		{VITA_C_IF_BAND_OFFSET, 2},
+		{VITA_C_REFERENCE_LEVEL, 1},
+		{VITA_C_GAIN, 1},
+		{VITA_C_OVER_RANGE_COUNT, 1},
+		{VITA_C_SAMPLE_RATE, 2},
+		{VITA_C_TIMESTAMP_ADJUSTMENT, 2},
+		{VITA_C_TIMESTAMP_CALIBRATION_TIME, 1},
+		{VITA_C_TEMPERATURE, 1},
+		{VITA_C_DEVICE_IDENTIFIER, 2},
+		{VITA_C_STATE_AND_EVENT_INDICATORS, 1},
+		{VITA_C_DATA_PACKET_PAYLOAD_FORMAT, 2},
+		{VITA_C_FORMATTED_GPS_GEOLOCATION, VITA_GEOLOCATION_WORDS},
+		{VITA_C_FORMATTED_INS_LOCATION, VITA_GEOLOCATION_WORDS},
+		{VITA_C_ECEF_EPHEMERIS, VITA_EPHEMERIS_WORDS},
+		{VITA_C_RELATIVE_EPHEMERIS, VITA_EPHEMERIS_WORDS},
+		{VITA_C_EPHEMERIS_REFERENCE_IND, 1},
+	}
+	n := 1
+	for _, w := range widths {
+		if c.Has(w.field) {
+			n += w.words
+		}
+	}
+	if c.Has(VITA_C_GPS_ASCII) {
+		if len(c.GPSASCII) < 2 || int(c.GPSASCII[1]) != len(c.GPSASCII)-2 {
+			return 0, ErrBadContextField
+		}
+		n += len(c.GPSASCII)
+	}
+	if c.Has(VITA_C_CONTEXT_ASSOCIATION_LISTS) {
+		lists := c.AssociationLists
+		if len(lists) < 2 || associationListsLen(lists[0], lists[1]) != len(lists) {
+			return 0, ErrBadContextField
+		}
+		n += len(lists)
+	}
+	return n, nil
+}
+
+/*
+ * Pack the indicator word and indicated fields of c into buffer, as the
+ * payload of a context packet. Returns the number of bytes packed.
+ */
+func PackVitaContextFields(c *VitaContext, buffer []byte) (int, error) {
+	nWords, err := c.fieldWords()
+	if err != nil {
+		return 0, err
+	}
+	if len(buffer) < nWords*4 {
+		return 0, ErrShortContextPacket
+	}
+	buf := buffer
+	word := func(w uint32) {
+		b.BigEndian.PutUint32(buf, w)
+		buf = buf[4:]
+	}
+	dword := func(d uint64) {
+		word(uint32(d >> 32))
+		word(uint32(d))
+	}
+	words := func(ws []uint32) {
+		for _, w := range ws {
+			word(w)
+		}
+	}
+
+	word(c.Indicator)
+	if c.Has(VITA_C_REFERENCE_POINT_IDENTIFIER) {
+		word(c.ReferencePoint)
+	}
+	if c.Has(VITA_C_BANDWIDTH) {
+		dword(uint64(floatToFixed(c.Bandwidth, vitaFreqRadix)))
+	}
+	if c.Has(VITA_C_IF_REFERENCE_FREQ) {
+		dword(uint64(floatToFixed(c.IFReferenceFreq, vitaFreqRadix)))
+	}
+	if c.Has(VITA_C_RF_REFERENCE_FREQ) {
+		dword(uint64(floatToFixed(c.RFReferenceFreq, vitaFreqRadix)))
+	}
+	if c.Has(VITA_C_RF_REFERENCE_FREQ_OFFSET) {
+		dword(uint64(floatToFixed(c.RFReferenceFreqOffset, vitaFreqRadix)))
+	}
+	if c.Has(VITA_C_IF_BAND_OFFSET) {
+		dword(uint64(floatToFixed(c.IFBandOffset, vitaFreqRadix)))
+	}
+	if c.Has(VITA_C_REFERENCE_LEVEL) {
+		word(uint32(uint16(floatToFixed(c.ReferenceLevel, vitaLevelRadix))))
+	}
+	if c.Has(VITA_C_GAIN) {
+		g2 := uint32(uint16(floatToFixed(c.Gain2, vitaLevelRadix)))
+		g1 := uint32(uint16(floatToFixed(c.Gain1, vitaLevelRadix)))
+		word(g2<<16 | g1)
+	}
+	if c.Has(VITA_C_OVER_RANGE_COUNT) {
+		word(c.OverRangeCount)
+	}
+	if c.Has(VITA_C_SAMPLE_RATE) {
+		dword(uint64(floatToFixed(c.SampleRate, vitaFreqRadix)))
+	}
+	if c.Has(VITA_C_TIMESTAMP_ADJUSTMENT) {
+		dword(uint64(c.TimestampAdjustment))
+	}
+	if c.Has(VITA_C_TIMESTAMP_CALIBRATION_TIME) {
+		word(c.TimestampCalibration)
+	}
+	if c.Has(VITA_C_TEMPERATURE) {
+		word(uint32(uint16(floatToFixed(c.Temperature, vitaTempRadix))))
+	}
+	if c.Has(VITA_C_DEVICE_IDENTIFIER) {
+		word(c.DeviceOUI & VITA_CLASS_ID_OUI_MASK)
+		word(uint32(c.DeviceCode))
+	}
+	if c.Has(VITA_C_STATE_AND_EVENT_INDICATORS) {
+		word(c.StateEvent)
+	}
+	if c.Has(VITA_C_DATA_PACKET_PAYLOAD_FORMAT) {
+		w1, w2 := c.PayloadFormat.pack()
+		word(w1)
+		word(w2)
+	}
+	if c.Has(VITA_C_FORMATTED_GPS_GEOLOCATION) {
+		words(c.GPSGeolocation[:])
+	}
+	if c.Has(VITA_C_FORMATTED_INS_LOCATION) {
+		words(c.INSLocation[:])
+	}
+	if c.Has(VITA_C_ECEF_EPHEMERIS) {
+		words(c.ECEFEphemeris[:])
+	}
+	if c.Has(VITA_C_RELATIVE_EPHEMERIS) {
+		words(c.RelativeEphemeris[:])
+	}
+	if c.Has(VITA_C_EPHEMERIS_REFERENCE_IND) {
+		word(c.EphemerisReference)
+	}
+	if c.Has(VITA_C_GPS_ASCII) {
+		words(c.GPSASCII)
+	}
+	if c.Has(VITA_C_CONTEXT_ASSOCIATION_LISTS) {
+		words(c.AssociationLists)
+	}
+	return nWords * 4, nil
+}
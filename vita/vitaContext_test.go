@@ -0,0 +1,240 @@
+/* SPDX-License-Identifier: GPL-3.0
+ *
+ * Copyright (C) 2018 Brady OBrien. All Rights Reserved.
+ */
+
+package vita
+
+import (
+	b "encoding/binary"
+	"reflect"
+	"testing"
+)
+
+const testContextStream uint32 = 0x81000000
+const testSampleRate = 24000
+
+/* Pack c the way SendContext does, as the sender would put it on the wire */
+func packContext(t *testing.T, c *VitaContext) []byte {
+	t.Helper()
+	buf, pkt := make([]byte, MAX_PACKET_LEN), &VitaIFData{}
+	if err := fillContextPacket(c, buf, pkt); err != nil {
+		t.Fatalf("fillContextPacket: %v", err)
+	}
+	wire := make([]byte, MAX_PACKET_LEN)
+	n := PackVifSendPacket(pkt, wire, 3)
+	if n == 0 {
+		t.Fatal("PackVifSendPacket packed nothing")
+	}
+	return wire[:n]
+}
+
+/* Pack c, parse it back, and compare everything but the header */
+func roundTripContext(t *testing.T, c *VitaContext) *VitaContext {
+	t.Helper()
+	c.Header.StreamID = testContextStream
+	c.Header.ClassIDH = 0x00001C2D
+	c.Header.ClassIDL = SL_VITA_SLICE_AUDIO_CLASS
+	wire := packContext(t, c)
+
+	got := &VitaContext{}
+	if err := ParseVitaContextPacket(wire, got); err != nil {
+		t.Fatalf("ParseVitaContextPacket: %v", err)
+	}
+	if got.Header.StreamID != testContextStream || got.Header.ClassIDL != SL_VITA_SLICE_AUDIO_CLASS {
+		t.Errorf("header came back as %+v", got.Header)
+	}
+	if got.Header.Header&VITA_HEADER_T_MASK != 0 {
+		t.Error("context packet went out with a trailer")
+	}
+	want := *c
+	want.Header, got.Header = VitaIfDataHeader{}, VitaIfDataHeader{}
+	if !reflect.DeepEqual(&want, got) {
+		t.Errorf("round trip of indicator %08X:\n got %+v\nwant %+v", c.Indicator, *got, want)
+	}
+	return got
+}
+
+func testGeolocation() (geo [VITA_GEOLOCATION_WORDS]uint32) {
+	for i := range geo {
+		geo[i] = 0x01010101 * uint32(i+1)
+	}
+	return
+}
+
+func testEphemeris() (eph [VITA_EPHEMERIS_WORDS]uint32) {
+	for i := range eph {
+		eph[i] = 0xA0000000 | uint32(i)
+	}
+	return
+}
+
+/* Two words of "GPGGA,1" after the OUI and word count */
+var testGPSASCII = []uint32{0x00001C2D, 2, 0x47504747, 0x412C3100}
+
+/*
+ * One source, one system and one vector-component association, and one
+ * asynchronous channel with its tag
+ */
+var testAssociationLists = []uint32{
+	0x00010001, 0x00018001,
+	0x81000001, 0x81000002, 0x81000003, 0x81000004, 0x00000007,
+}
+
+/* Every field on its own, with a value that survives its fixed-point format */
+var contextFieldTests = []struct {
+	name string
+	c    VitaContext
+}{
+	{"change", VitaContext{Indicator: VITA_C_CONTEXT_FIELD_CHG_INDICATOR}},
+	{"reference point", VitaContext{Indicator: VITA_C_REFERENCE_POINT_IDENTIFIER, ReferencePoint: 0x81000000}},
+	{"bandwidth", VitaContext{Indicator: VITA_C_BANDWIDTH, Bandwidth: 12000.5}},
+	{"IF reference", VitaContext{Indicator: VITA_C_IF_REFERENCE_FREQ, IFReferenceFreq: -1500.25}},
+	{"RF reference", VitaContext{Indicator: VITA_C_RF_REFERENCE_FREQ, RFReferenceFreq: 14236000}},
+	{"RF offset", VitaContext{Indicator: VITA_C_RF_REFERENCE_FREQ_OFFSET, RFReferenceFreqOffset: -600}},
+	{"IF band offset", VitaContext{Indicator: VITA_C_IF_BAND_OFFSET, IFBandOffset: 1500.125}},
+	{"reference level", VitaContext{Indicator: VITA_C_REFERENCE_LEVEL, ReferenceLevel: -10.5}},
+	{"gain", VitaContext{Indicator: VITA_C_GAIN, Gain1: 12.25, Gain2: -3.5}},
+	{"over range", VitaContext{Indicator: VITA_C_OVER_RANGE_COUNT, OverRangeCount: 17}},
+	{"sample rate", VitaContext{Indicator: VITA_C_SAMPLE_RATE, SampleRate: 24000}},
+	{"timestamp adjustment", VitaContext{Indicator: VITA_C_TIMESTAMP_ADJUSTMENT, TimestampAdjustment: -123456789}},
+	{"timestamp calibration", VitaContext{Indicator: VITA_C_TIMESTAMP_CALIBRATION_TIME, TimestampCalibration: 1234567890}},
+	{"temperature", VitaContext{Indicator: VITA_C_TEMPERATURE, Temperature: -5.75}},
+	{"device", VitaContext{Indicator: VITA_C_DEVICE_IDENTIFIER, DeviceOUI: 0x001C2D, DeviceCode: 0x1234}},
+	{"state and event", VitaContext{Indicator: VITA_C_STATE_AND_EVENT_INDICATORS,
+		StateEvent: VITA_S_VALID_DATA_E | VITA_S_VALID_DATA_I | VITA_S_OVER_RANGE_E | 0x5}},
+	{"payload format", VitaContext{Indicator: VITA_C_DATA_PACKET_PAYLOAD_FORMAT, PayloadFormat: VitaPayloadFormat{
+		LinkEfficient: true, RealComplex: VITA_P_COMPLEX_CARTESIAN, DataItemFormat: VITA_P_IEEE_754_SINGLE,
+		SampleRepeat: true, EventTagSize: 3, ChannelTagSize: 5,
+		FieldSize: 32, ItemSize: 32, RepeatCount: 2, VectorSize: 64}}},
+	{"GPS geolocation", VitaContext{Indicator: VITA_C_FORMATTED_GPS_GEOLOCATION, GPSGeolocation: testGeolocation()}},
+	{"INS location", VitaContext{Indicator: VITA_C_FORMATTED_INS_LOCATION, INSLocation: testGeolocation()}},
+	{"ECEF ephemeris", VitaContext{Indicator: VITA_C_ECEF_EPHEMERIS, ECEFEphemeris: testEphemeris()}},
+	{"relative ephemeris", VitaContext{Indicator: VITA_C_RELATIVE_EPHEMERIS, RelativeEphemeris: testEphemeris()}},
+	{"ephemeris reference", VitaContext{Indicator: VITA_C_EPHEMERIS_REFERENCE_IND, EphemerisReference: 0x81000001}},
+	{"GPS ASCII", VitaContext{Indicator: VITA_C_GPS_ASCII, GPSASCII: testGPSASCII}},
+	{"association lists", VitaContext{Indicator: VITA_C_CONTEXT_ASSOCIATION_LISTS, AssociationLists: testAssociationLists}},
+}
+
+func TestContextFieldRoundTrip(t *testing.T) {
+	for _, tc := range contextFieldTests {
+		t.Run(tc.name, func(t *testing.T) {
+			c := tc.c
+			roundTripContext(t, &c)
+		})
+	}
+}
+
+/* Every field at once, so each must land at the right offset */
+func TestContextAllFieldsRoundTrip(t *testing.T) {
+	c := &VitaContext{}
+	for _, tc := range contextFieldTests {
+		field := tc.c
+		c.Indicator |= field.Indicator
+		/* Copy over whichever member this entry set */
+		v, fv := reflect.ValueOf(c).Elem(), reflect.ValueOf(field)
+		for i := 0; i < fv.NumField(); i++ {
+			if name := fv.Type().Field(i).Name; name != "Indicator" && name != "Header" && !fv.Field(i).IsZero() {
+				v.Field(i).Set(fv.Field(i))
+			}
+		}
+	}
+	roundTripContext(t, c)
+}
+
+/* A mix of fixed and variable-length fields */
+func TestContextMixedRoundTrip(t *testing.T) {
+	c := &VitaContext{
+		Indicator: VITA_C_CONTEXT_FIELD_CHG_INDICATOR | VITA_C_BANDWIDTH | VITA_C_GAIN |
+			VITA_C_SAMPLE_RATE | VITA_C_DATA_PACKET_PAYLOAD_FORMAT | VITA_C_GPS_ASCII |
+			VITA_C_CONTEXT_ASSOCIATION_LISTS,
+		Bandwidth:        3000,
+		Gain1:            -0.5,
+		SampleRate:       testSampleRate,
+		PayloadFormat:    VitaPayloadFormat{RealComplex: VITA_P_REAL, FieldSize: 16, ItemSize: 16, RepeatCount: 1, VectorSize: 1},
+		GPSASCII:         testGPSASCII,
+		AssociationLists: testAssociationLists,
+	}
+	roundTripContext(t, c)
+}
+
+/* Field sizes of the packed payload must match the indicator word */
+func TestContextFieldWords(t *testing.T) {
+	c := &VitaContext{
+		Indicator:     VITA_C_SAMPLE_RATE | VITA_C_DATA_PACKET_PAYLOAD_FORMAT,
+		SampleRate:    testSampleRate,
+		PayloadFormat: VitaPayloadFormat{FieldSize: 32, ItemSize: 32, RepeatCount: 1, VectorSize: 1},
+	}
+	buf := make([]byte, 64)
+	n, err := PackVitaContextFields(c, buf)
+	if err != nil || n != 20 {
+		t.Fatalf("PackVitaContextFields packed %d bytes, %v; want 20", n, err)
+	}
+	if rate := b.BigEndian.Uint64(buf[4:]); rate != testSampleRate<<vitaFreqRadix {
+		t.Errorf("sample rate word %X", rate)
+	}
+	if _, err := PackVitaContextFields(c, buf[:16]); err != ErrShortContextPacket {
+		t.Errorf("packing into a short buffer gave %v", err)
+	}
+}
+
+func TestContextErrors(t *testing.T) {
+	base := &VitaContext{
+		Header:    VitaIfDataHeader{StreamID: testContextStream},
+		Indicator: VITA_C_BANDWIDTH | VITA_C_GPS_ASCII,
+		Bandwidth: 3000,
+		GPSASCII:  testGPSASCII,
+	}
+	wire := packContext(t, base)
+	/* Payload starts after the 7 word header PackVifSendPacket writes */
+	const indicatorOff = 28
+	c := &VitaContext{}
+
+	reserved := append([]byte(nil), wire...)
+	b.BigEndian.PutUint32(reserved[indicatorOff:], base.Indicator|0x01)
+	if err := ParseVitaContextPacket(reserved, c); err != ErrReservedContextField {
+		t.Errorf("reserved indicator bit: got %v", err)
+	}
+	bad := *base
+	bad.Indicator |= 0x80 & VITA_C_RESERVED
+	if _, err := PackVitaContextFields(&bad, make([]byte, MAX_PACKET_LEN)); err != ErrReservedContextField {
+		t.Errorf("packing a reserved indicator bit: got %v", err)
+	}
+
+	/* Cut off the last word, both in the buffer and in the header size */
+	if err := ParseVitaContextPacket(wire[:len(wire)-4], c); err != ErrShortContextPacket {
+		t.Errorf("truncated buffer: got %v", err)
+	}
+	shortened := append([]byte(nil), wire...)
+	hdr := b.BigEndian.Uint32(shortened)
+	b.BigEndian.PutUint32(shortened, hdr-1)
+	if err := ParseVitaContextPacket(shortened, c); err != ErrShortContextPacket {
+		t.Errorf("truncated packet size: got %v", err)
+	}
+	/* GPS ASCII word count, after the indicator, bandwidth and OUI, running past the end */
+	overrun := append([]byte(nil), wire...)
+	b.BigEndian.PutUint32(overrun[indicatorOff+16:], 3)
+	if err := ParseVitaContextPacket(overrun, c); err != ErrShortContextPacket {
+		t.Errorf("overlong GPS ASCII: got %v", err)
+	}
+	if err := ParseVitaContextPacket(wire[:3], c); err != ErrShortContextPacket {
+		t.Errorf("three byte packet: got %v", err)
+	}
+
+	data := append([]byte(nil), wire...)
+	b.BigEndian.PutUint32(data, hdr&^VITA_HEADER_PACKET_TYPE_MASK|VITA_PACKET_TYPE_IF_DATA_WITH_STREAM_ID)
+	if err := ParseVitaContextPacket(data, c); err != ErrNotContextPacket {
+		t.Errorf("data packet: got %v", err)
+	}
+
+	/* Variable-length fields whose own counts disagree with their length */
+	badGPS := *base
+	badGPS.GPSASCII = []uint32{0x00001C2D, 5, 0}
+	if _, err := PackVitaContextFields(&badGPS, make([]byte, MAX_PACKET_LEN)); err != ErrBadContextField {
+		t.Errorf("inconsistent GPS ASCII: got %v", err)
+	}
+	badLists := VitaContext{Indicator: VITA_C_CONTEXT_ASSOCIATION_LISTS, AssociationLists: testAssociationLists[:5]}
+	if _, err := PackVitaContextFields(&badLists, make([]byte, MAX_PACKET_LEN)); err != ErrBadContextField {
+		t.Errorf("inconsistent association lists: got %v", err)
+	}
+}
@@ -0,0 +1,114 @@
+/* SPDX-License-Identifier: GPL-3.0
+ *
+ * Copyright (C) 2018 Brady OBrien. All Rights Reserved.
+ */
+
+package vita
+
+import (
+	"bytes"
+	"testing"
+)
+
+/* Pack a data packet carrying trailer and parse it back */
+func roundTripTrailer(t *testing.T, trailer VitaTrailer) *VitaIFData {
+	t.Helper()
+	payload := []byte{1, 2, 3, 4, 5, 6, 7, 8}
+	pkt := &VitaIFData{
+		Header:    VitaIfDataHeader{StreamID: testContextStream},
+		Trailer:   trailer,
+		DataBytes: payload,
+	}
+	wire := make([]byte, MAX_PACKET_LEN)
+	n := PackVifSendPacket(pkt, wire, 5)
+	if n == 0 {
+		t.Fatal("PackVifSendPacket packed nothing")
+	}
+	got := &VitaIFData{}
+	if !ParseVitaDataPacket(wire[:n], got) {
+		t.Fatal("ParseVitaDataPacket rejected the packet")
+	}
+	if !bytes.Equal(got.DataBytes, payload) {
+		t.Errorf("payload came back as %v", got.DataBytes)
+	}
+	if got.Trailer != trailer {
+		t.Errorf("trailer came back as %+v, want %+v", got.Trailer, trailer)
+	}
+	return got
+}
+
+func TestTrailerRoundTrip(t *testing.T) {
+	var trailer VitaTrailer
+	trailer.SetIndicator(VITA_S_VALID_DATA_I, true)
+	trailer.SetIndicator(VITA_S_OVER_RANGE_I, true)
+	trailer.SetIndicator(VITA_S_SAMPLE_LOSS_I, false)
+	trailer.SetContextCount(3)
+	got := roundTripTrailer(t, trailer).Trailer
+
+	for _, tc := range []struct {
+		indicator uint32
+		flagged   bool
+	}{
+		{VITA_S_VALID_DATA_I, true},
+		{VITA_S_OVER_RANGE_I, true},
+		{VITA_S_SAMPLE_LOSS_I, false},
+		{VITA_S_AGC_INDICATOR_I, false},
+	} {
+		if got.Flagged(tc.indicator) != tc.flagged {
+			t.Errorf("indicator %08X flagged %v, want %v", tc.indicator, !tc.flagged, tc.flagged)
+		}
+	}
+	if on, enabled := got.Indicator(VITA_S_SAMPLE_LOSS_I); on || !enabled {
+		t.Errorf("sample loss came back on=%v enabled=%v", on, enabled)
+	}
+	if _, enabled := got.Indicator(VITA_S_AGC_INDICATOR_I); enabled {
+		t.Error("AGC indicator came back enabled")
+	}
+	if n, ok := got.ContextCount(); !ok || n != 3 {
+		t.Errorf("context count %d %v, want 3", n, ok)
+	}
+}
+
+func TestTrailerAbsent(t *testing.T) {
+	got := roundTripTrailer(t, VitaTrailer{}).Trailer
+	if got.Present {
+		t.Error("packet without a trailer parsed with one")
+	}
+	if _, ok := got.ContextCount(); ok {
+		t.Error("context count given without a trailer")
+	}
+}
+
+/* An indicator bit means nothing unless its enable bit is set */
+func TestTrailerFlaggedNeedsEnable(t *testing.T) {
+	trailer := VitaTrailer{Present: true, Word: VITA_S_OVER_RANGE_I | VITA_S_SAMPLE_LOSS_I | VITA_S_SAMPLE_LOSS_E}
+	if trailer.Flagged(VITA_S_OVER_RANGE_I) {
+		t.Error("over range flagged with its enable bit off")
+	}
+	if !trailer.Flagged(VITA_S_SAMPLE_LOSS_I) {
+		t.Error("sample loss not flagged with its enable bit on")
+	}
+	trailer.Present = false
+	if trailer.Flagged(VITA_S_SAMPLE_LOSS_I) {
+		t.Error("sample loss flagged on an absent trailer")
+	}
+}
+
+/* Clearing an indicator leaves it enabled; the count is masked to its 7 bits */
+func TestTrailerSetters(t *testing.T) {
+	var trailer VitaTrailer
+	trailer.SetIndicator(VITA_S_OVER_RANGE_I, true)
+	trailer.SetIndicator(VITA_S_OVER_RANGE_I, false)
+	if trailer.Word != VITA_S_OVER_RANGE_E {
+		t.Errorf("trailer word %08X, want %08X", trailer.Word, VITA_S_OVER_RANGE_E)
+	}
+	trailer.SetContextCount(5)
+	trailer.SetContextCount(2)
+	if n, ok := trailer.ContextCount(); !ok || n != 2 {
+		t.Errorf("context count %d %v after reset, want 2", n, ok)
+	}
+	trailer.SetContextCount(200)
+	if n, _ := trailer.ContextCount(); n != 200&int(VITA_TRAILER_CONTEXT_COUNT_MASK) {
+		t.Errorf("context count %d, want it masked to 7 bits", n)
+	}
+}
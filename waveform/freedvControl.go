@@ -0,0 +1,208 @@
+/* SPDX-License-Identifier: GPL-3.0
+ *
+ * Copyright (C) 2018 Brady O'Brien. All Rights Reserved.
+ *
+ * Handling of waveform commands sent by the radio on behalf of the GUI
+ */
+
+package waveform
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/baobrien/smartsdr-golang/api"
+	"github.com/baobrien/smartsdr-golang/freedv"
+)
+
+/* Modes operators may select; all run the modem at 8 kHz */
+var FREEDV_WAVEFORM_MODES = []freedv.FreedvMode{
+	freedv.FREEDV_MODE_1600,
+	freedv.FREEDV_MODE_700C,
+	freedv.FREEDV_MODE_700D,
+	freedv.FREEDV_MODE_2020,
+}
+
+const WAVEFORM_STATUS_TIMEOUT = 2 * time.Second
+const FREEDV_STATS_INTERVAL = 500 * time.Millisecond
+const FREEDV_TEXT_EVENT_LEN = 16
+
+/* Applies waveform commands to the running modem and reports back */
+type FreedvController struct {
+	fdv *freedv.Freedv
+	mgr *api.ConnectionManager
+
+	lock sync.Mutex
+	/* Slice the waveform was last commanded on, for status reports */
+	slice     int
+	haveSlice bool
+	/* Closed when the last queued status report has gone out */
+	lastReport chan int
+
+	/* Held across checking and changing the modem mode */
+	modeLock sync.Mutex
+
+	/* Lines of text received over the air, delivered without blocking */
+	RxText chan string
+}
+
+func NewFreedvController(fdv *freedv.Freedv, mgr *api.ConnectionManager) *FreedvController {
+	ctl := &FreedvController{
+		fdv:    fdv,
+		mgr:    mgr,
+		RxText: make(chan string, FREEDV_TEXT_EVENT_LEN),
+	}
+	fdv.SetTextHandler(ctl.handleRxText)
+	return ctl
+}
+
+func waveformModeAllowed(mode freedv.FreedvMode) bool {
+	for _, m := range FREEDV_WAVEFORM_MODES {
+		if m == mode {
+			return true
+		}
+	}
+	return false
+}
+
+/* Send a waveform status line for slice to the radio */
+func (ctl *FreedvController) reportStatus(slice int, status string) {
+	cmd := fmt.Sprintf("waveform status slice=%d %s", slice, status)
+	ctx, cancel := context.WithTimeout(context.Background(), WAVEFORM_STATUS_TIMEOUT)
+	defer cancel()
+	if _, err := ctl.mgr.RunCommand(ctx, cmd); err != nil {
+		ctl.mgr.ReportError(err)
+	}
+}
+
+/*
+ * Send a status report in the background, after any reports queued before
+ * it, so the command handler doesn't wait on a round trip to the radio.
+ * Nothing orders the report against the R reply to the command being
+ * handled; either may reach the radio first.
+ */
+func (ctl *FreedvController) queueStatus(slice int, status string) {
+	ctl.lock.Lock()
+	prev := ctl.lastReport
+	done := make(chan int)
+	ctl.lastReport = done
+	ctl.lock.Unlock()
+	go func() {
+		defer close(done)
+		if prev != nil {
+			<-prev
+		}
+		ctl.reportStatus(slice, status)
+	}()
+}
+
+/*
+ * CommandHandler for "slice <n> waveform_cmd key=value ...". Recognised
+ * keys are:
+ *   mode=<1600|700C|700D|2020>  reopen the modem in another mode
+ *   tx_text=<text>              text to send alongside voice
+ */
+func (ctl *FreedvController) HandleSliceCommand(argv []string) (string, uint32) {
+	if len(argv) < 4 || argv[2] != "waveform_cmd" {
+		return "", uint32(api.SL_MALFORMED_COMMAND)
+	}
+	slice, err := strconv.Atoi(argv[1])
+	if err != nil {
+		return "", uint32(api.SL_INVALID_SLICE_RECEIVER)
+	}
+	ctl.lock.Lock()
+	ctl.slice = slice
+	ctl.haveSlice = true
+	ctl.lock.Unlock()
+	toks := api.Tokenize(strings.Join(argv[3:], " "))
+	handled := false
+	if modeName, ok := toks.Values["mode"]; ok {
+		mode, err := freedv.ParseFreedvMode(modeName)
+		if err != nil || !waveformModeAllowed(mode) {
+			return "unknown mode " + modeName, uint32(api.SL_BAD_FIELD)
+		}
+		ctl.modeLock.Lock()
+		if mode != ctl.fdv.Mode() {
+			if err := ctl.fdv.Reopen(mode); err != nil {
+				ctl.modeLock.Unlock()
+				return err.Error(), uint32(api.SL_MALLOC_FAIL_DSP_PROCESS)
+			}
+		}
+		/* Queued under modeLock, so reports follow the order modes were set */
+		ctl.queueStatus(slice, "mode="+mode.String())
+		ctl.modeLock.Unlock()
+		handled = true
+	}
+	if txText, ok := toks.Values["tx_text"]; ok {
+		ctl.fdv.SetTxText(txText)
+		ctl.queueStatus(slice, "tx_text="+api.EscapeTokenValue(txText))
+		handled = true
+	}
+	if !handled {
+		return "", uint32(api.SL_BAD_FIELD)
+	}
+	return "", uint32(api.SL_SUCCESS)
+}
+
+/* Pass text received from the modem on to the radio and to RxText */
+func (ctl *FreedvController) handleRxText(line string) {
+	if slice, ok := ctl.currentSlice(); ok && ctl.mgr.State() == api.CONN_CONNECTED {
+		ctl.reportStatus(slice, "text="+api.EscapeTokenValue(line))
+	}
+	select {
+	case ctl.RxText <- line:
+	default:
+	}
+}
+
+/* Slice to report status on; false until the radio has sent a waveform_cmd */
+func (ctl *FreedvController) currentSlice() (int, bool) {
+	ctl.lock.Lock()
+	defer ctl.lock.Unlock()
+	return ctl.slice, ctl.haveSlice
+}
+
+/*
+ * Report modem statistics every interval until quit is signalled: as
+ * waveform status once a slice is known, and through meters if meters
+ * is not nil
+ */
+func (ctl *FreedvController) StatsLoop(interval time.Duration, meters *WaveformMeters, quit chan int) {
+	var snrMeter, foffMeter *WaveformMeter
+	if meters != nil {
+		snrMeter = meters.AddMeter("fdv-snr", "dB", -10, 40)
+		foffMeter = meters.AddMeter("fdv-foff", "Hz", -100, 100)
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-quit:
+			return
+		case <-ticker.C:
+		}
+		if ctl.mgr.State() != api.CONN_CONNECTED {
+			continue
+		}
+		stats := ctl.fdv.GetStats()
+		sync := 0
+		if stats.Sync {
+			sync = 1
+		}
+		if slice, ok := ctl.currentSlice(); ok {
+			ctl.reportStatus(slice, fmt.Sprintf("snr=%.1f sync=%d foff=%.1f clock_offset=%.6f ber=%.6f",
+				stats.SNR, sync, stats.FreqOffset, stats.ClockOffset, stats.BER()))
+		}
+		if meters != nil {
+			meters.Set(snrMeter, stats.SNR)
+			meters.Set(foffMeter, stats.FreqOffset)
+			if err := meters.Send(); err != nil {
+				ctl.mgr.ReportError(err)
+			}
+		}
+	}
+}
@@ -0,0 +1,117 @@
+//go:build nocodec2
+
+/* SPDX-License-Identifier: GPL-3.0
+ *
+ * Copyright (C) 2018 Brady O'Brien. All Rights Reserved.
+ */
+
+package waveform
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/baobrien/smartsdr-golang/api"
+	"github.com/baobrien/smartsdr-golang/freedv"
+)
+
+const testTimeout = 2 * time.Second
+
+var testVersion = api.FlexVersion{Major: 2, Minor: 4, DevA: 9}
+
+/* A controller on the loopback modem, managed against a mock radio */
+func startTestController(t *testing.T) (*FreedvController, *api.MockRadio) {
+	t.Helper()
+	mock := api.NewMockRadio(testVersion, 1)
+	addr, err := mock.Listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	fdv, err := freedv.FreedvOpen(freedv.FREEDV_MODE_700D)
+	if err != nil {
+		t.Fatalf("FreedvOpen: %v", err)
+	}
+	mgr := api.NewConnectionManager(addr.String(), nil)
+	ctl := NewFreedvController(fdv, mgr)
+	mgr.RegisterCommandHandler("slice", ctl.HandleSliceCommand)
+	runErr := make(chan error, 1)
+	go func() {
+		runErr <- mgr.Run()
+	}()
+	t.Cleanup(func() {
+		mgr.Close()
+		<-runErr
+		mock.Close()
+		fdv.Close()
+	})
+	timeout := time.After(testTimeout)
+	for mgr.State() != api.CONN_CONNECTED {
+		select {
+		case <-mgr.StateChanges:
+		case <-timeout:
+			t.Fatal("manager never connected")
+		}
+	}
+	return ctl, mock
+}
+
+func TestSliceCommands(t *testing.T) {
+	ctl, mock := startTestController(t)
+	ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+	defer cancel()
+
+	for _, tc := range []struct {
+		cmd    string
+		status uint32
+	}{
+		{"slice 1 waveform_cmd mode=2020", uint32(api.SL_SUCCESS)},
+		{"slice 1 waveform_cmd mode=2400A", uint32(api.SL_BAD_FIELD)},
+		{"slice 1 waveform_cmd squelch=1", uint32(api.SL_BAD_FIELD)},
+		{"slice x waveform_cmd mode=700D", uint32(api.SL_INVALID_SLICE_RECEIVER)},
+		{"slice 1 waveform_cmd", uint32(api.SL_MALFORMED_COMMAND)},
+	} {
+		if _, status, err := mock.SendCommand(ctx, tc.cmd); err != nil || status != tc.status {
+			t.Errorf("%s: status %08X %v, want %08X", tc.cmd, status, err, tc.status)
+		}
+	}
+	if mode := ctl.fdv.Mode(); mode != freedv.FREEDV_MODE_2020 {
+		t.Errorf("modem in %s after mode=2020", mode.String())
+	}
+	if _, err := mock.WaitForCommand(ctx, "waveform status slice=1 mode=2020"); err != nil {
+		t.Error(err)
+	}
+}
+
+/* TX text set by the radio loops back through the modem and is reported as received */
+func TestTextLoopback(t *testing.T) {
+	ctl, mock := startTestController(t)
+	ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+	defer cancel()
+
+	cmd := "slice 0 waveform_cmd tx_text=" + api.EscapeTokenValue("N0CALL Bob")
+	if _, status, err := mock.SendCommand(ctx, cmd); err != nil || status != 0 {
+		t.Fatalf("tx_text: status %08X %v", status, err)
+	}
+	if _, err := mock.WaitForCommand(ctx, "waveform status slice=0 tx_text=N0CALL\x7fBob"); err != nil {
+		t.Error(err)
+	}
+
+	sz := ctl.fdv.FrameSizes()
+	modS := make([]int16, sz.NomModemSamps)
+	speechS := make([]int16, sz.NSpeechSamples)
+	for i := 0; i <= len("N0CALL Bob"); i++ {
+		ctl.fdv.Tx(modS, speechS)
+	}
+	select {
+	case line := <-ctl.RxText:
+		if line != "N0CALL Bob" {
+			t.Errorf("received %q", line)
+		}
+	case <-time.After(testTimeout):
+		t.Fatal("no text received")
+	}
+	if _, err := mock.WaitForCommand(ctx, "waveform status slice=0 text=N0CALL\x7fBob"); err != nil {
+		t.Error(err)
+	}
+}
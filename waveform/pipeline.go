@@ -0,0 +1,282 @@
+/* SPDX-License-Identifier: GPL-3.0
+ *
+ * Copyright (C) 2018 Brady O'Brien. All Rights Reserved.
+ *
+ * Processing chains between the radio's waveform streams and the modem
+ */
+
+package waveform
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/baobrien/smartsdr-golang/dsp"
+	"github.com/baobrien/smartsdr-golang/freedv"
+	"github.com/baobrien/smartsdr-golang/vita"
+)
+
+/*
+ * Stream IDs the radio uses for waveform audio. Received modem audio and
+ * demodulated speech share one ID; mic audio for transmit comes in on the
+ * TX ID and modulated samples go back out on it.
+ */
+const WAVEFORM_RX_STREAM_ID uint32 = 0x81000000
+const WAVEFORM_TX_STREAM_ID uint32 = 0x81000001
+
+/* Rate of the audio streams to and from the radio */
+const WAVEFORM_STREAM_RATE = 24000
+
+/* How often stream context is re-sent; VITA_PERIODIC in vita49_context.h */
+const STREAM_CONTEXT_INTERVAL = time.Second
+
+/* How often stream conditions and losses are checked for logging */
+const STREAM_REPORT_INTERVAL = 10 * time.Second
+
+/* A running chain from a VITA stream subscriber to the VITA sender */
+type Pipeline struct {
+	vif      *vita.VitaInterface
+	streamID uint32
+	input    chan []float32
+	done     chan int
+	header   *vita.VitaIfDataHeader
+
+	conditions   dsp.StreamConditions
+	clock        *dsp.StreamClock
+	lock         sync.Mutex
+	inputContext *vita.VitaContext
+}
+
+/*
+ * Subscribe to streamID and its context, feeding the first of chans. The
+ * subscriber maps aren't locked, so vif must not be listening yet.
+ */
+func newPipeline(vif *vita.VitaInterface, streamID uint32, chans []chan []float32) *Pipeline {
+	pl := &Pipeline{
+		vif:      vif,
+		streamID: streamID,
+		input:    chans[0],
+		done:     make(chan int),
+		clock:    dsp.NewStreamClock(WAVEFORM_STREAM_RATE),
+	}
+	/* Fill in for lost packets so the resamplers and modem keep time */
+	input := dsp.StVitaInputFillF(chans[0], &pl.conditions, dsp.GAP_FILL_ZERO)
+	vif.Subscribers[streamID] = func(pkt *vita.VitaIFData, pool *vita.VitaBufferPool) {
+		/* What we send back is stamped in the time base of what we receive */
+		if pkt.Sequence.Event == vita.SEQ_IN_ORDER {
+			pl.clock.Observe(vita.TimestampFromHeader(&pkt.Header))
+		}
+		input(pkt, pool)
+	}
+	vif.ContextSubscribers[streamID] = pl.handleContext
+	return pl
+}
+
+func (pl *Pipeline) handleContext(c *vita.VitaContext) {
+	pl.lock.Lock()
+	pl.inputContext = c
+	pl.lock.Unlock()
+}
+
+/* Over-range, sample loss and invalid data seen on our input stream so far */
+func (pl *Pipeline) Conditions() dsp.StreamConditions {
+	return pl.conditions.Snapshot()
+}
+
+/* Packets lost, duplicated and reordered on our input stream so far */
+func (pl *Pipeline) ReceiveStats() vita.StreamStats {
+	stats, _ := pl.vif.ReceiveStats(pl.streamID)
+	return stats
+}
+
+/* Most recent context the radio sent for our input stream, or nil */
+func (pl *Pipeline) InputContext() *vita.VitaContext {
+	pl.lock.Lock()
+	defer pl.lock.Unlock()
+	return pl.inputContext
+}
+
+/* Context advertising the format of the stream we send: 24kHz float stereo */
+func (pl *Pipeline) OutputContext() *vita.VitaContext {
+	return &vita.VitaContext{
+		Header:     *pl.header,
+		Indicator:  vita.VITA_C_SAMPLE_RATE | vita.VITA_C_DATA_PACKET_PAYLOAD_FORMAT,
+		SampleRate: WAVEFORM_STREAM_RATE,
+		PayloadFormat: vita.VitaPayloadFormat{
+			/* Left and right go out as the two halves of a complex sample */
+			RealComplex:    vita.VITA_P_COMPLEX_CARTESIAN,
+			DataItemFormat: vita.VITA_P_IEEE_754_SINGLE,
+			FieldSize:      32,
+			ItemSize:       32,
+			RepeatCount:    1,
+			VectorSize:     1,
+		},
+	}
+}
+
+/*
+ * Send the output context of each pipeline now and every interval until
+ * quit is closed. The first round is flagged as a change of context.
+ * Contexts which can't be packed are logged and skipped.
+ */
+func AdvertiseStreams(vif *vita.VitaInterface, interval time.Duration, quit chan int, pipelines ...*Pipeline) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	changed := vita.VITA_C_CONTEXT_FIELD_CHG_INDICATOR
+	for {
+		for _, pl := range pipelines {
+			c := pl.OutputContext()
+			c.Indicator |= changed
+			if err := vif.SendContext(c); err != nil {
+				fmt.Printf("Could not send context for stream %08X: %v\n", c.Header.StreamID, err)
+			}
+		}
+		changed = 0
+		select {
+		case <-quit:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+/*
+ * Log the trailer conditions and packet losses on each pipeline's input
+ * every interval until quit is closed, whenever they have changed
+ */
+func ReportStreams(interval time.Duration, quit chan int, pipelines ...*Pipeline) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	lastCond := make([]dsp.StreamConditions, len(pipelines))
+	lastStats := make([]vita.StreamStats, len(pipelines))
+	for {
+		select {
+		case <-quit:
+			return
+		case <-ticker.C:
+		}
+		for i, pl := range pipelines {
+			cond, stats := pl.Conditions(), pl.ReceiveStats()
+			if cond == lastCond[i] && stats == lastStats[i] {
+				continue
+			}
+			lastCond[i], lastStats[i] = cond, stats
+			fmt.Printf("Stream %08X: %d packets, %d dropped, %d duplicated, %d reordered; "+
+				"%d over range, %d sample loss, %d invalid\n",
+				pl.streamID, stats.Received, stats.Dropped, stats.Duplicated, stats.Reordered,
+				cond.OverRange, cond.SampleLoss, cond.InvalidData)
+		}
+	}
+}
+
+/* Start the output stage, marking the pipeline done when it has drained */
+func (pl *Pipeline) startOutput(in chan []float32, header *vita.VitaIfDataHeader) {
+	pl.header = header
+	go func() {
+		dsp.StVitaOutputClockF(in, pl.vif, header, pl.clock)
+		close(pl.done)
+	}()
+}
+
+/*
+ * Drain the pipeline by pushing a nil buffer through every stage, and wait
+ * for the last of its packets to be queued for sending. The VITA listen loop
+ * must have stopped first, as this removes the stream subscriber.
+ */
+func (pl *Pipeline) Stop(ctx context.Context) error {
+	delete(pl.vif.Subscribers, pl.streamID)
+	delete(pl.vif.ContextSubscribers, pl.streamID)
+	select {
+	case pl.input <- nil:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	select {
+	case <-pl.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+/* Start the receive chain; vif must not be listening yet */
+func StartFdvRxer(vif *vita.VitaInterface, fdv freedv.Modem) *Pipeline {
+	ch := make([]chan []float32, 6)
+	for v := range ch {
+		ch[v] = make(chan []float32, 2)
+	}
+	chp := 0
+
+	/* Add vita to []float input thing */
+	pl := newPipeline(vif, WAVEFORM_RX_STREAM_ID, ch)
+
+	go dsp.SampCtrF(ch[chp], ch[chp+1], "RX In ", time.Second)
+	chp++
+
+	/* Start 24Khz to 8Khz stage */
+	go dsp.StResamp24to8F(ch[chp], ch[chp+1], 256)
+	chp++
+
+	go freedv.StFreedvRxF(ch[chp], ch[chp+1], fdv)
+	chp++
+
+	/* Start 8Khz to 24Khz stage */
+	go dsp.StResamp8to24F(ch[chp], ch[chp+1], 256)
+	chp++
+
+	go dsp.SampCtrF(ch[chp], ch[chp+1], "RX Out", time.Second)
+	chp++
+
+	templateHeader := &vita.VitaIfDataHeader{
+		StreamID:       WAVEFORM_RX_STREAM_ID,
+		ClassIDH:       0x00001C2D,
+		ClassIDL:       vita.SL_VITA_SLICE_AUDIO_CLASS,
+		TimestampFracH: 0,
+		TimestampFracL: 0,
+		TimestampInt:   0,
+	}
+	pl.startOutput(ch[chp], templateHeader)
+	return pl
+}
+
+/* Start the transmit chain; vif must not be listening yet */
+func StartFdvTxer(vif *vita.VitaInterface, fdv freedv.Modem) *Pipeline {
+	ch := make([]chan []float32, 6)
+	for v := range ch {
+		ch[v] = make(chan []float32, 2)
+	}
+	chp := 0
+
+	/* Mic audio from the radio */
+	pl := newPipeline(vif, WAVEFORM_TX_STREAM_ID, ch)
+
+	go dsp.SampCtrF(ch[chp], ch[chp+1], "TX In ", time.Second)
+	chp++
+
+	/* Start 24Khz to 8Khz stage */
+	go dsp.StResamp24to8F(ch[chp], ch[chp+1], 256)
+	chp++
+
+	go freedv.StFreedvTxF(ch[chp], ch[chp+1], fdv)
+	chp++
+
+	/* Start 8Khz to 24Khz stage */
+	go dsp.StResamp8to24F(ch[chp], ch[chp+1], 256)
+	chp++
+
+	go dsp.SampCtrF(ch[chp], ch[chp+1], "TX Out", time.Second)
+	chp++
+
+	templateHeader := &vita.VitaIfDataHeader{
+		StreamID:       WAVEFORM_TX_STREAM_ID,
+		ClassIDH:       0x00001C2D,
+		ClassIDL:       vita.SL_VITA_SLICE_AUDIO_CLASS,
+		TimestampFracH: 0,
+		TimestampFracL: 0,
+		TimestampInt:   0,
+	}
+	pl.startOutput(ch[chp], templateHeader)
+	return pl
+}
@@ -0,0 +1,122 @@
+/* SPDX-License-Identifier: GPL-3.0
+ *
+ * Copyright (C) 2018 Brady O'Brien. All Rights Reserved.
+ *
+ * Values of the radio's own meters, decoded from its meter stream
+ */
+
+package waveform
+
+import (
+	b "encoding/binary"
+	"sync"
+
+	"github.com/baobrien/smartsdr-golang/api"
+	"github.com/baobrien/smartsdr-golang/vita"
+)
+
+/* Stream on which the radio sends meter values */
+const RADIO_METER_STREAM_ID uint32 = 0x00000700
+
+/* One entry of a meter packet, before scaling */
+type MeterReading struct {
+	ID  uint16
+	Raw int16
+}
+
+/* Split a meter packet payload into its ID/value pairs */
+func ParseMeterPayload(data []byte) []MeterReading {
+	readings := make([]MeterReading, 0, len(data)/METER_ENTRY_LEN)
+	for len(data) >= METER_ENTRY_LEN {
+		readings = append(readings, MeterReading{
+			ID:  b.BigEndian.Uint16(data),
+			Raw: int16(b.BigEndian.Uint16(data[2:])),
+		})
+		data = data[METER_ENTRY_LEN:]
+	}
+	return readings
+}
+
+/* Called with the scaled value of each meter as it arrives */
+type MeterHandler func(meter api.MeterInfo, value float32)
+
+/*
+ * Latest values of the radio's meters. Names and units come from the
+ * registry, so "sub meter all" must be in effect for values to be usable.
+ */
+type RadioMeters struct {
+	Registry *api.MeterRegistry
+
+	lock    sync.Mutex
+	raw     map[uint16]int16
+	handler MeterHandler
+}
+
+func NewRadioMeters(registry *api.MeterRegistry) *RadioMeters {
+	return &RadioMeters{Registry: registry, raw: make(map[uint16]int16)}
+}
+
+/*
+ * Register with mgr for meter status, and with vif for the meter stream.
+ * Call before vif's listen loop is started.
+ */
+func (rm *RadioMeters) Attach(mgr *api.ConnectionManager, vif *vita.VitaInterface) {
+	/* Meter descriptions come in a burst on subscribing; don't lose any */
+	mgr.RegisterStatusHandlerQueued("meter", rm.Registry.HandleStatus, api.DEFAULT_HANDLER_QUEUE_LEN, api.OVERFLOW_BLOCK)
+	mgr.Subscribe("sub meter all")
+	vif.Subscribers[RADIO_METER_STREAM_ID] = rm.StreamSubscriber()
+}
+
+/* Set a handler for every meter value received; it must not block */
+func (rm *RadioMeters) SetHandler(handler MeterHandler) {
+	rm.lock.Lock()
+	rm.handler = handler
+	rm.lock.Unlock()
+}
+
+/* Stream subscriber which decodes meter packets into rm */
+func (rm *RadioMeters) StreamSubscriber() vita.StreamSubscriber {
+	return func(pkt *vita.VitaIFData, pool *vita.VitaBufferPool) {
+		var readings []MeterReading
+		if pkt.Header.ClassIDL&vita.VITA_CLASS_ID_PACKET_CLASS_MASK == SL_VITA_METER_CLASS&vita.VITA_CLASS_ID_PACKET_CLASS_MASK {
+			readings = ParseMeterPayload(pkt.DataBytes)
+		}
+		pool.ReleasePB(pkt.RawPacketBuffer, pkt)
+
+		rm.lock.Lock()
+		handler := rm.handler
+		for _, r := range readings {
+			rm.raw[r.ID] = r.Raw
+		}
+		rm.lock.Unlock()
+		if handler == nil {
+			return
+		}
+		for _, r := range readings {
+			if meter, ok := rm.Registry.Meter(r.ID); ok {
+				handler(meter, meter.Value(r.Raw))
+			}
+		}
+	}
+}
+
+/* Latest value of a meter, if both its value and description have arrived */
+func (rm *RadioMeters) Value(id uint16) (float32, bool) {
+	meter, ok := rm.Registry.Meter(id)
+	if !ok {
+		return 0, false
+	}
+	rm.lock.Lock()
+	raw, ok := rm.raw[id]
+	rm.lock.Unlock()
+	return meter.Value(raw), ok
+}
+
+/* Latest value of a meter found by source and name, e.g. ("SLC", "0", "LEVEL") */
+func (rm *RadioMeters) ValueByName(source, sourceIndex, name string) (float32, bool) {
+	meter, ok := rm.Registry.Find(source, sourceIndex, name)
+	if !ok {
+		return 0, false
+	}
+	return rm.Value(meter.ID)
+}
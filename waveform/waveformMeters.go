@@ -0,0 +1,164 @@
+/* SPDX-License-Identifier: GPL-3.0
+ *
+ * Copyright (C) 2018 Brady O'Brien. All Rights Reserved.
+ *
+ * Meters created by the waveform, shown by SmartSDR alongside the radio's own
+ */
+
+package waveform
+
+import (
+	"context"
+	b "encoding/binary"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/baobrien/smartsdr-golang/api"
+	"github.com/baobrien/smartsdr-golang/vita"
+)
+
+/* Stream on which the waveform sends its meter values */
+const WAVEFORM_METER_STREAM_ID uint32 = 0x88000000
+const SL_VITA_METER_CLASS uint32 = (vita.SL_VITA_INFO_CLASS << 16) | 0x8002
+
+/* Bytes per meter in a meter packet: 16 bit ID, 16 bit value */
+const METER_ENTRY_LEN = 4
+
+/* Room for meters once the 7 word header is packed in front */
+const MAX_METER_PAYLOAD_LEN = vita.MAX_PACKET_LEN - 28
+
+type WaveformMeter struct {
+	Name string
+	Unit string
+	Min  float32
+	Max  float32
+
+	/* Assigned by the radio; zero until created in the current session */
+	id    uint16
+	value float32
+	set   bool
+}
+
+/* Fixed point value of meter, clipped to the range of the wire format */
+func (meter *WaveformMeter) wireValue() int16 {
+	v := meter.value * api.MeterScale(meter.Unit)
+	if v > 32767 {
+		return 32767
+	}
+	if v < -32768 {
+		return -32768
+	}
+	return int16(v)
+}
+
+/*
+ * The waveform's meters. Meters are created on the radio the first time
+ * they are sent in each session, so they survive reconnects.
+ */
+type WaveformMeters struct {
+	mgr *api.ConnectionManager
+	vif *vita.VitaInterface
+
+	lock    sync.Mutex
+	meters  []*WaveformMeter
+	session *api.SmartAPIInterface
+}
+
+func NewWaveformMeters(mgr *api.ConnectionManager, vif *vita.VitaInterface) *WaveformMeters {
+	return &WaveformMeters{mgr: mgr, vif: vif, meters: make([]*WaveformMeter, 0)}
+}
+
+func (wm *WaveformMeters) AddMeter(name, unit string, min, max float32) *WaveformMeter {
+	wm.lock.Lock()
+	defer wm.lock.Unlock()
+	meter := &WaveformMeter{Name: name, Unit: unit, Min: min, Max: max}
+	wm.meters = append(wm.meters, meter)
+	return meter
+}
+
+/* Record a new value, to go out with the next Send */
+func (wm *WaveformMeters) Set(meter *WaveformMeter, value float32) {
+	wm.lock.Lock()
+	defer wm.lock.Unlock()
+	meter.value = value
+	meter.set = true
+}
+
+/* Create meter on the radio; the response is the new meter's ID */
+func createMeter(ctx context.Context, session *api.SmartAPIInterface, meter *WaveformMeter) (uint16, error) {
+	cmd := fmt.Sprintf("meter create name=%s type=WAVEFORM min=%f max=%f unit=%s fps=20",
+		meter.Name, meter.Min, meter.Max, meter.Unit)
+	resp, err := session.RunCommand(ctx, cmd)
+	if err != nil {
+		return 0, err
+	}
+	id, err := strconv.ParseUint(strings.TrimSpace(resp), 10, 16)
+	if err != nil {
+		return 0, fmt.Errorf("createMeter %s: bad meter id %q", meter.Name, resp)
+	}
+	return uint16(id), nil
+}
+
+/* Make sure every meter exists in the current session. Must hold lock */
+func (wm *WaveformMeters) createMetersLocked(session *api.SmartAPIInterface) error {
+	if session != wm.session {
+		for _, meter := range wm.meters {
+			meter.id = 0
+		}
+		wm.session = session
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	for _, meter := range wm.meters {
+		if meter.id != 0 {
+			continue
+		}
+		id, err := createMeter(ctx, session, meter)
+		if err != nil {
+			return err
+		}
+		meter.id = id
+	}
+	return nil
+}
+
+/* Send every meter which has a value in one meter packet */
+func (wm *WaveformMeters) Send() error {
+	session := wm.mgr.API()
+	if session == nil {
+		return api.ErrNotConnected
+	}
+	wm.lock.Lock()
+	defer wm.lock.Unlock()
+	if err := wm.createMetersLocked(session); err != nil {
+		return err
+	}
+
+	buf, pkt := wm.vif.BufBag.GrabPB()
+	pkt.RawPacketBuffer = buf
+	pkt.Header = vita.VitaIfDataHeader{
+		Header:   vita.VITA_PACKET_TYPE_EXT_DATA_WITH_STREAM_ID,
+		StreamID: WAVEFORM_METER_STREAM_ID,
+		ClassIDH: 0x00001C2D,
+		ClassIDL: SL_VITA_METER_CLASS,
+	}
+	n := 0
+	for _, meter := range wm.meters {
+		if !meter.set || n+METER_ENTRY_LEN > MAX_METER_PAYLOAD_LEN {
+			continue
+		}
+		b.BigEndian.PutUint16(buf[n:], meter.id)
+		b.BigEndian.PutUint16(buf[n+2:], uint16(meter.wireValue()))
+		n += METER_ENTRY_LEN
+	}
+	if n == 0 {
+		wm.vif.BufBag.ReleasePB(buf, pkt)
+		return nil
+	}
+	pkt.DataBytes = buf[:n]
+	wm.vif.SendChannel <- pkt
+	return nil
+}